@@ -0,0 +1,105 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"arsenal-hardware/util"
+)
+
+// Entry 一次故障注入或清理操作的审计记录，按行追加到arsenal/logs/audit.log文件。
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	Ops       string            `json:"ops"`
+	FaultType string            `json:"fault-type"`
+	Flags     map[string]string `json:"flags"`
+	Options   map[string]string `json:"options,omitempty"`
+	Succeeded bool              `json:"succeeded"`
+	Error     string            `json:"error,omitempty"`
+}
+
+func getAuditPath() (string, error) {
+	arsenalLogDir, err := util.GetArsenalLogsDir()
+	if err != nil {
+		return "", fmt.Errorf("get arsenal logs dir failed(%v)", err)
+	}
+	if err := os.MkdirAll(arsenalLogDir, 0750); err != nil {
+		return "", fmt.Errorf("create logs dir(%s) failed(%v)", arsenalLogDir, err)
+	}
+	return filepath.Join(arsenalLogDir, "audit.log"), nil
+}
+
+// Write 追加一条审计记录，单行写入依赖O_APPEND保证多个进程并发写入时不会交错。
+func Write(entry *Entry) error {
+	auditPath, err := getAuditPath()
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry failed(%v)", err)
+	}
+
+	f, err := os.OpenFile(auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("open audit log(%s) failed(%v)", auditPath, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(content, '\n')); err != nil {
+		return fmt.Errorf("write audit log(%s) failed(%v)", auditPath, err)
+	}
+	return nil
+}
+
+// List 按时间顺序返回since之后的审计记录，无法解析的行会被跳过。
+func List(since time.Time) ([]*Entry, error) {
+	auditPath, err := getAuditPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(auditPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open audit log(%s) failed(%v)", auditPath, err)
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			continue
+		}
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log(%s) failed(%v)", auditPath, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"arsenal-hardware/internal/parse"
+	"arsenal-hardware/internal/state"
+	"arsenal-hardware/submodules"
+	"arsenal-hardware/util"
+)
+
+func init() {
+	submodules.AddCommand("chaos", chaos)
+}
+
+// eventArgs 将计划中的事件转换为故障注入清理的输入参数。
+func eventArgs(program string, event *Event) []string {
+	// 故障模式名的第一段为模块名，如：pcie-reset-abnormal。
+	names := strings.SplitN(event.FaultType, "-", 2)
+	args := []string{program, event.Ops, names[0], names[1]}
+
+	flagNames := make([]string, 0, len(event.Flags))
+	for name := range event.Flags {
+		flagNames = append(flagNames, name)
+	}
+	sort.Strings(flagNames)
+	for _, name := range flagNames {
+		args = append(args, fmt.Sprintf("--%s", name), event.Flags[name])
+	}
+	return args
+}
+
+func (e *Event) String() string {
+	return strings.Join(eventArgs("", e)[1:], " ")
+}
+
+// isActive 判断事件对应的故障是否仍处于注入状态，清理失败时据此判断故障是否已被清理，如：清理后检测到恢复遗漏。
+func isActive(event *Event) bool {
+	active, err := state.Active()
+	if err != nil {
+		return true
+	}
+	for _, record := range active {
+		kind, name := record.Target()
+		if record.FaultType == event.FaultType && event.Flags[kind] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// savePlan 保存故障序列，返回计划文件与执行日志的路径。
+func savePlan(plan *Plan) (string, string, error) {
+	arsenalLogDir, err := util.GetArsenalLogsDir()
+	if err != nil {
+		return "", "", fmt.Errorf("get arsenal logs dir failed(%v)", err)
+	}
+	chaosDir := filepath.Join(arsenalLogDir, "chaos")
+	if err := os.MkdirAll(chaosDir, 0750); err != nil {
+		return "", "", fmt.Errorf("create chaos dir(%s) failed(%v)", chaosDir, err)
+	}
+
+	name := fmt.Sprintf("chaos-%d", time.Now().UnixNano())
+	planPath := filepath.Join(chaosDir, fmt.Sprintf("%s-plan.json", name))
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("marshal chaos plan failed(%v)", err)
+	}
+	if err := ioutil.WriteFile(planPath, content, 0640); err != nil {
+		return "", "", fmt.Errorf("write chaos plan(%s) failed(%v)", planPath, err)
+	}
+	return planPath, filepath.Join(chaosDir, fmt.Sprintf("%s.log", name)), nil
+}
+
+// runEvent 在子进程中执行一个故障操作。故障处理对象是进程内的单例，子进程保证每个事件的参数互不影响。
+func runEvent(args []string) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("get executable path failed(%v)", err)
+	}
+	output, err := exec.Command(exePath, args[1:]...).CombinedOutput()
+	if err != nil {
+		if result := strings.TrimSpace(string(output)); result != "" {
+			return fmt.Errorf("%v: %s", err, strings.ReplaceAll(result, "\n", "; "))
+		}
+		return err
+	}
+	return nil
+}
+
+// runPlan 按计划时间依次执行故障注入与清理，收到退出信号时清理本次注入且未清理的故障后返回。
+func runPlan(program string, plan *Plan, logFile *os.File) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(stop)
+
+	logEvent := func(event *Event, result string) {
+		line := fmt.Sprintf("%s +%s %s: %s\n", time.Now().Format(time.RFC3339), event.Offset, event, result)
+		fmt.Print(line)
+		_, _ = logFile.WriteString(line)
+	}
+
+	// injected 记录已注入且未清理的故障，注入失败的故障不再执行对应的清理。
+	injected := make(map[string]*Event)
+	var failures int
+	start := time.Now()
+	for _, event := range plan.Events {
+		timer := time.NewTimer(time.Until(start.Add(event.Offset)))
+		select {
+		case <-stop:
+			timer.Stop()
+			return removeInjected(program, injected, start, logEvent)
+		case <-timer.C:
+		}
+
+		key := event.String()
+		if event.Ops == submodules.Remove {
+			key = strings.Replace(key, submodules.Remove, submodules.Inject, 1)
+			if _, ok := injected[key]; !ok {
+				logEvent(event, "skipped, not injected")
+				continue
+			}
+		}
+
+		if err := runEvent(eventArgs(program, event)); err != nil {
+			failures++
+			logEvent(event, fmt.Sprintf("failed(%v)", err))
+			// 清理失败的故障保持注入状态，测试结束前继续尝试清理。
+			if event.Ops == submodules.Remove && !isActive(event) {
+				delete(injected, key)
+			}
+			continue
+		}
+		logEvent(event, "succeeded")
+		if event.Ops == submodules.Inject {
+			injected[key] = event
+		} else {
+			delete(injected, key)
+		}
+	}
+
+	if err := removeInjected(program, injected, start, logEvent); err != nil {
+		return err
+	}
+	if failures != 0 {
+		return fmt.Errorf("chaos finished with %d failed events", failures)
+	}
+	return nil
+}
+
+// removeInjected 清理本次混沌测试注入且未清理的故障。
+func removeInjected(program string, injected map[string]*Event, start time.Time,
+	logEvent func(*Event, string)) error {
+	var leftovers []string
+	for key, event := range injected {
+		removeEvent := *event
+		removeEvent.Ops = submodules.Remove
+		removeEvent.Offset = time.Since(start)
+		if err := runEvent(eventArgs(program, &removeEvent)); err != nil {
+			logEvent(&removeEvent, fmt.Sprintf("failed(%v)", err))
+			if isActive(&removeEvent) {
+				leftovers = append(leftovers, key)
+			}
+			continue
+		}
+		logEvent(&removeEvent, "succeeded")
+	}
+	if len(leftovers) != 0 {
+		sort.Strings(leftovers)
+		return fmt.Errorf("faults not removed: %s", strings.Join(leftovers, "; "))
+	}
+	return nil
+}
+
+// chaos 按随机种子生成故障序列并执行：arsenal-hardware chaos --config chaos.json [--dry-run true]，
+// 重放已保存的故障序列：arsenal-hardware chaos --replay chaos-xxx-plan.json。
+func chaos(inputArgs []string) error {
+	flags := parse.TransInputFlagsToMap(inputArgs)
+	var plan *Plan
+	var err error
+	if replayPath, ok := flags["replay"]; ok {
+		plan, err = LoadPlan(replayPath)
+	} else if configPath, ok := flags["config"]; ok {
+		var c *Config
+		if c, err = LoadConfig(configPath); err == nil {
+			plan = c.GeneratePlan()
+		}
+	} else {
+		return fmt.Errorf("missing param: config or replay")
+	}
+	if err != nil {
+		return err
+	}
+
+	planPath, logPath, err := savePlan(plan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("chaos seed: %d, events: %d, plan: %s\n", plan.Seed, len(plan.Events), planPath)
+	if flags["dry-run"] == "true" {
+		for _, event := range plan.Events {
+			fmt.Printf("+%s %s\n", event.Offset, event)
+		}
+		return nil
+	}
+
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("open chaos log(%s) failed(%v)", logPath, err)
+	}
+	defer logFile.Close()
+	fmt.Printf("chaos log: %s\n", logPath)
+	return runPlan(inputArgs[0], plan, logFile)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"time"
+
+	"arsenal-hardware/submodules"
+)
+
+// Fault 混沌测试允许注入的故障，Target为故障目标参数名，如：interface、device、bdf。
+type Fault struct {
+	FaultType string            `json:"fault-type"`
+	Target    string            `json:"target"`
+	Args      map[string]string `json:"args"`
+}
+
+// Config 混沌测试配置，targets按目标参数名列出允许注入故障的目标。
+type Config struct {
+	Seed     int64               `json:"seed"`
+	Duration string              `json:"duration"`
+	Interval string              `json:"interval"`
+	HoldMin  string              `json:"hold-min"`
+	HoldMax  string              `json:"hold-max"`
+	Faults   []Fault             `json:"faults"`
+	Targets  map[string][]string `json:"targets"`
+
+	duration time.Duration
+	interval time.Duration
+	holdMin  time.Duration
+	holdMax  time.Duration
+}
+
+// Event 混沌测试计划中的一次故障注入或清理，Offset为相对测试开始的时间。
+type Event struct {
+	Offset    time.Duration     `json:"offset"`
+	Ops       string            `json:"ops"`
+	FaultType string            `json:"fault-type"`
+	Flags     map[string]string `json:"flags"`
+}
+
+// Plan 由随机种子生成的完整故障序列，保存后可以重放。
+type Plan struct {
+	Seed   int64    `json:"seed"`
+	Events []*Event `json:"events"`
+}
+
+func parseDuration(name string, value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return duration, nil
+}
+
+// LoadConfig 读取JSON格式的混沌测试配置并校验，未指定seed时使用当前时间。
+func LoadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read chaos config(%s) failed(%v)", path, err)
+	}
+	c := &Config{}
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("parse chaos config(%s) failed(%v)", path, err)
+	}
+
+	if c.duration, err = parseDuration("duration", c.Duration); err != nil {
+		return nil, err
+	}
+	if c.interval, err = parseDuration("interval", c.Interval); err != nil {
+		return nil, err
+	}
+	if c.holdMin, err = parseDuration("hold-min", c.HoldMin); err != nil {
+		return nil, err
+	}
+	if c.holdMax, err = parseDuration("hold-max", c.HoldMax); err != nil || c.holdMax < c.holdMin {
+		return nil, fmt.Errorf("invalid hold-max: %s", c.HoldMax)
+	}
+	if len(c.Faults) == 0 {
+		return nil, fmt.Errorf("chaos config missing faults")
+	}
+	for _, fault := range c.Faults {
+		if _, ok := submodules.FaultTypes[fault.FaultType]; !ok {
+			return nil, fmt.Errorf("unsupported fault type: %s", fault.FaultType)
+		}
+		if len(c.Targets[fault.Target]) == 0 {
+			return nil, fmt.Errorf("%s missing targets: %s", fault.FaultType, fault.Target)
+		}
+	}
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	return c, nil
+}
+
+// randomDuration 返回[min, max]范围内的随机时长。
+func randomDuration(r *rand.Rand, min time.Duration, max time.Duration) time.Duration {
+	return min + time.Duration(r.Int63n(int64(max-min)+1))
+}
+
+// freeTargets 获取故障类型当前没有被占用的目标，每个目标同一时间只注入一个故障。
+func (c *Config) freeTargets(fault Fault, busy map[string]time.Duration, now time.Duration) []string {
+	var targets []string
+	for _, target := range c.Targets[fault.Target] {
+		if releaseTime, ok := busy[target]; !ok || releaseTime <= now {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// GeneratePlan 根据随机种子生成故障序列，相同的配置与种子生成相同的序列。
+// 注入间隔在[interval/2, interval*3/2]范围内随机，故障持续时长在[hold-min, hold-max]范围内随机，
+// 测试结束时所有故障都会被清理。
+func (c *Config) GeneratePlan() *Plan {
+	r := rand.New(rand.NewSource(c.Seed))
+	plan := &Plan{Seed: c.Seed}
+	busy := make(map[string]time.Duration)
+
+	for now := randomDuration(r, c.interval/2, c.interval*3/2); now < c.duration; now += randomDuration(r, c.interval/2, c.interval*3/2) {
+		fault := c.Faults[r.Intn(len(c.Faults))]
+		targets := c.freeTargets(fault, busy, now)
+		if len(targets) == 0 {
+			continue
+		}
+		target := targets[r.Intn(len(targets))]
+		releaseTime := now + randomDuration(r, c.holdMin, c.holdMax)
+		if releaseTime > c.duration {
+			releaseTime = c.duration
+		}
+		busy[target] = releaseTime
+
+		flags := map[string]string{fault.Target: target}
+		for name, value := range fault.Args {
+			flags[name] = value
+		}
+		plan.Events = append(plan.Events,
+			&Event{Offset: now, Ops: submodules.Inject, FaultType: fault.FaultType, Flags: flags},
+			&Event{Offset: releaseTime, Ops: submodules.Remove, FaultType: fault.FaultType, Flags: flags})
+	}
+
+	// 同一时刻先清理再注入，保证目标释放后才会被再次使用。
+	sort.SliceStable(plan.Events, func(i, j int) bool {
+		if plan.Events[i].Offset != plan.Events[j].Offset {
+			return plan.Events[i].Offset < plan.Events[j].Offset
+		}
+		return plan.Events[i].Ops == submodules.Remove && plan.Events[j].Ops != submodules.Remove
+	})
+	return plan
+}
+
+// LoadPlan 读取保存的故障序列用于重放。
+func LoadPlan(path string) (*Plan, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read chaos plan(%s) failed(%v)", path, err)
+	}
+	plan := &Plan{}
+	if err := json.Unmarshal(content, plan); err != nil {
+		return nil, fmt.Errorf("parse chaos plan(%s) failed(%v)", path, err)
+	}
+	for _, event := range plan.Events {
+		if _, ok := submodules.FaultTypes[event.FaultType]; !ok {
+			return nil, fmt.Errorf("unsupported fault type: %s", event.FaultType)
+		}
+	}
+	return plan, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"arsenal-hardware/submodules"
+)
+
+func newTestConfig(seed int64) *Config {
+	return &Config{
+		Seed: seed,
+		Faults: []Fault{
+			{FaultType: "network-down", Target: "interface"},
+			{FaultType: "network-delay", Target: "interface", Args: map[string]string{"delay": "100ms"}},
+			{FaultType: "pcie-reset-abnormal", Target: "bdf"},
+		},
+		Targets: map[string][]string{
+			"interface": {"eth1", "eth2"},
+			"bdf":       {"0000:3b:00.0"},
+		},
+		duration: 10 * time.Minute,
+		interval: 20 * time.Second,
+		holdMin:  10 * time.Second,
+		holdMax:  time.Minute,
+	}
+}
+
+func TestGeneratePlanDeterministic(t *testing.T) {
+	first := newTestConfig(42).GeneratePlan()
+	second := newTestConfig(42).GeneratePlan()
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("same seed generated different plans")
+	}
+	if len(first.Events) == 0 {
+		t.Fatal("plan has no event")
+	}
+	if first.Seed != 42 {
+		t.Errorf("plan seed = %d, want 42", first.Seed)
+	}
+	if other := newTestConfig(43).GeneratePlan(); reflect.DeepEqual(first.Events, other.Events) {
+		t.Error("different seeds generated the same plan")
+	}
+}
+
+func TestGeneratePlanOrdering(t *testing.T) {
+	for _, seed := range []int64{1, 42, 20231001} {
+		c := newTestConfig(seed)
+		plan := c.GeneratePlan()
+
+		// 目标上同一时间只有一个故障，每次注入都有对应的清理。
+		active := make(map[string]*Event)
+		for i, event := range plan.Events {
+			if i > 0 {
+				previous := plan.Events[i-1]
+				if event.Offset < previous.Offset {
+					t.Fatalf("seed %d: event %d at %v before previous event at %v", seed, i, event.Offset, previous.Offset)
+				}
+				if event.Offset == previous.Offset && event.Ops == submodules.Remove && previous.Ops != submodules.Remove {
+					t.Fatalf("seed %d: remove at %v after inject at the same time", seed, event.Offset)
+				}
+			}
+			if event.Offset > c.duration {
+				t.Fatalf("seed %d: event at %v after test duration %v", seed, event.Offset, c.duration)
+			}
+
+			target := event.Flags["interface"] + event.Flags["bdf"]
+			switch event.Ops {
+			case submodules.Inject:
+				if injected, ok := active[target]; ok {
+					t.Fatalf("seed %d: %s injected at %v while %s is active", seed, event, event.Offset, injected)
+				}
+				active[target] = event
+			case submodules.Remove:
+				injected, ok := active[target]
+				if !ok || injected.FaultType != event.FaultType || !reflect.DeepEqual(injected.Flags, event.Flags) {
+					t.Fatalf("seed %d: %s removed at %v without matching inject", seed, event, event.Offset)
+				}
+				if hold := event.Offset - injected.Offset; hold > c.holdMax || (hold < c.holdMin && event.Offset != c.duration) {
+					t.Fatalf("seed %d: %s held for %v", seed, event, hold)
+				}
+				delete(active, target)
+			default:
+				t.Fatalf("seed %d: unexpected ops %s", seed, event.Ops)
+			}
+		}
+		if len(active) != 0 {
+			t.Errorf("seed %d: %d faults not removed at the end", seed, len(active))
+		}
+	}
+}
+
+func TestEventArgs(t *testing.T) {
+	event := &Event{Ops: submodules.Inject, FaultType: "pcie-reset-abnormal",
+		Flags: map[string]string{"bdf": "0000:3b:00.0", "ack-timeout": "5s"}}
+	want := []string{"ah", "inject", "pcie", "reset-abnormal", "--ack-timeout", "5s", "--bdf", "0000:3b:00.0"}
+	if got := eventArgs("ah", event); !reflect.DeepEqual(got, want) {
+		t.Errorf("eventArgs() = %q, want %q", got, want)
+	}
+	if got, want := event.String(), "inject pcie reset-abnormal --ack-timeout 5s --bdf 0000:3b:00.0"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"arsenal-hardware/internal/parse"
+	"arsenal-hardware/submodules"
+	"arsenal-hardware/util"
+)
+
+func init() {
+	submodules.AddCommand("doctor", doctor)
+}
+
+// checkCommands doctor需要检查的命令。
+var checkCommands = []string{"tc", "iptables", "nmcli", "ifconfig", "ifup", "ifdown"}
+
+type commandStatus struct {
+	Name    string `json:"name"`
+	Present bool   `json:"present"`
+}
+
+type netemStatus struct {
+	Loadable bool   `json:"loadable"`
+	Detail   string `json:"detail"`
+}
+
+type blockDevice struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type pcieDevice struct {
+	BDF           string `json:"bdf"`
+	Reset         bool   `json:"reset"`
+	Parent        string `json:"parent"`
+	HotplugParent bool   `json:"hotplug-parent"`
+}
+
+type faultStatus struct {
+	FaultType string `json:"fault-type"`
+	Ready     bool   `json:"ready"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type report struct {
+	KernelVersion string          `json:"kernel-version"`
+	Commands      []commandStatus `json:"commands"`
+	Netem         netemStatus     `json:"netem"`
+	BlockDevices  []blockDevice   `json:"block-devices"`
+	PcieDevices   []pcieDevice    `json:"pcie-devices"`
+	Faults        []faultStatus   `json:"faults"`
+}
+
+func (r *report) commandIsPresent(name string) bool {
+	for _, command := range r.Commands {
+		if command.Name == name {
+			return command.Present
+		}
+	}
+	return false
+}
+
+func getKernelVersion() string {
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(release))
+}
+
+func getCommandsStatus() []commandStatus {
+	status := make([]commandStatus, 0, len(checkCommands))
+	for _, command := range checkCommands {
+		_, isMissCmd := util.CheckEnvShellCommand([]string{command})
+		status = append(status, commandStatus{Name: command, Present: !isMissCmd})
+	}
+	return status
+}
+
+func getNetemStatus() netemStatus {
+	if util.KernelModuleIsLoaded("sch_netem") {
+		return netemStatus{Loadable: true, Detail: "loaded"}
+	}
+
+	// modprobe -n 只做检查，不会真正加载模块。
+	checkCmd := "modprobe -n sch_netem"
+	if result, err := util.ExecCommandBlock(checkCmd); err != nil {
+		return netemStatus{Loadable: false, Detail: strings.TrimSpace(fmt.Sprintf("%v %s", err, result))}
+	}
+	return netemStatus{Loadable: true, Detail: "not loaded, loadable"}
+}
+
+func getBlockDevices() []blockDevice {
+	devices := make([]blockDevice, 0)
+	statePaths, err := filepath.Glob("/sys/block/*/device/state")
+	if err != nil {
+		return devices
+	}
+	for _, statePath := range statePaths {
+		content, err := ioutil.ReadFile(statePath)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, blockDevice{
+			// /sys/block/sda/device/state
+			Name:  filepath.Base(filepath.Dir(filepath.Dir(statePath))),
+			State: strings.TrimSpace(string(content)),
+		})
+	}
+	return devices
+}
+
+// getHotplugSlotAddresses 获取所有pcie插槽地址，如：0000:02:00。
+func getHotplugSlotAddresses() map[string]bool {
+	addresses := make(map[string]bool)
+	addressPaths, err := filepath.Glob("/sys/bus/pci/slots/*/address")
+	if err != nil {
+		return addresses
+	}
+	for _, addressPath := range addressPaths {
+		content, err := ioutil.ReadFile(addressPath)
+		if err != nil {
+			continue
+		}
+		addresses[strings.TrimSpace(string(content))] = true
+	}
+	return addresses
+}
+
+func getPcieDevices() []pcieDevice {
+	devices := make([]pcieDevice, 0)
+	devicePaths, err := filepath.Glob("/sys/bus/pci/devices/*")
+	if err != nil {
+		return devices
+	}
+
+	slotAddresses := getHotplugSlotAddresses()
+	for _, devicePath := range devicePaths {
+		bdf := filepath.Base(devicePath)
+		device := pcieDevice{
+			BDF:   bdf,
+			Reset: util.FileIsExist(filepath.Join(devicePath, "reset")),
+		}
+		if realPath, err := filepath.EvalSymlinks(devicePath); err == nil {
+			device.Parent = filepath.Base(filepath.Dir(realPath))
+		}
+		// 插槽地址不包含function号，示例：bdf为0000:02:00.0，插槽地址为0000:02:00。
+		if index := strings.LastIndex(bdf, "."); index > 0 {
+			device.HotplugParent = slotAddresses[bdf[:index]]
+		}
+		if device.Reset || device.HotplugParent {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// getFaultStatus 根据主机环境判断故障模式是否可用。
+func (r *report) getFaultStatus(faultType string) faultStatus {
+	status := faultStatus{FaultType: faultType, Ready: true}
+	notReady := func(reason string) faultStatus {
+		status.Ready = false
+		status.Reason = reason
+		return status
+	}
+
+	switch faultType {
+	case "network-delay", "network-loss", "network-corrupt", "network-duplicate", "network-reorder", "network-rate",
+		"network-trace", "network-profile":
+		if !r.commandIsPresent("tc") {
+			return notReady("missing command tc")
+		}
+		if !r.Netem.Loadable {
+			return notReady("kernel module sch_netem not loadable")
+		}
+	case "network-package-drop", "network-unavailable":
+		if !r.commandIsPresent("iptables") {
+			return notReady("missing command iptables")
+		}
+	case "network-down":
+		if !r.commandIsPresent("nmcli") && !r.commandIsPresent("ifconfig") &&
+			!(r.commandIsPresent("ifup") && r.commandIsPresent("ifdown")) {
+			return notReady("missing command nmcli, ifconfig or ifup/ifdown")
+		}
+	case "disk-blocked", "disk-offline":
+		if len(r.BlockDevices) == 0 {
+			return notReady("no block device has device/state")
+		}
+	case "pcie-reset-abnormal":
+		for _, device := range r.PcieDevices {
+			if device.Reset {
+				return status
+			}
+		}
+		return notReady("no pcie device has reset attribute")
+	case "pcie-offline":
+		for _, device := range r.PcieDevices {
+			if device.HotplugParent {
+				return status
+			}
+		}
+		return notReady("no pcie device has hotplug-capable parent")
+	default:
+		return notReady("no readiness check")
+	}
+	return status
+}
+
+func collectReport() *report {
+	r := &report{
+		KernelVersion: getKernelVersion(),
+		Commands:      getCommandsStatus(),
+		Netem:         getNetemStatus(),
+		BlockDevices:  getBlockDevices(),
+		PcieDevices:   getPcieDevices(),
+	}
+
+	faultTypes := make([]string, 0, len(submodules.FaultTypes))
+	for faultType := range submodules.FaultTypes {
+		faultTypes = append(faultTypes, faultType)
+	}
+	sort.Strings(faultTypes)
+	for _, faultType := range faultTypes {
+		r.Faults = append(r.Faults, r.getFaultStatus(faultType))
+	}
+	return r
+}
+
+func yesOrNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+func (r *report) printTable() error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "KERNEL\t%s\n\n", r.KernelVersion)
+
+	fmt.Fprintln(w, "COMMAND\tPRESENT")
+	for _, command := range r.Commands {
+		fmt.Fprintf(w, "%s\t%s\n", command.Name, yesOrNo(command.Present))
+	}
+	fmt.Fprintf(w, "\nSCH_NETEM\t%s\t%s\n\n", yesOrNo(r.Netem.Loadable), r.Netem.Detail)
+
+	fmt.Fprintln(w, "BLOCK DEVICE\tSTATE")
+	for _, device := range r.BlockDevices {
+		fmt.Fprintf(w, "%s\t%s\n", device.Name, device.State)
+	}
+
+	fmt.Fprintln(w, "\nPCIE DEVICE\tRESET\tPARENT\tHOTPLUG PARENT")
+	for _, device := range r.PcieDevices {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", device.BDF, yesOrNo(device.Reset), device.Parent,
+			yesOrNo(device.HotplugParent))
+	}
+
+	fmt.Fprintln(w, "\nFAULT TYPE\tREADY\tREASON")
+	for _, fault := range r.Faults {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", fault.FaultType, yesOrNo(fault.Ready), fault.Reason)
+	}
+	return w.Flush()
+}
+
+func (r *report) printJSON() error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+// doctor 输出当前主机对各故障模式的支持情况：arsenal-hardware doctor --format json。
+func doctor(inputArgs []string) error {
+	r := collectReport()
+	format := parse.TransInputFlagsToMap(inputArgs)["format"]
+	switch format {
+	case "", "table":
+		return r.printTable()
+	case "json":
+		return r.printJSON()
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
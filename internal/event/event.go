@@ -0,0 +1,182 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"arsenal-hardware/util"
+)
+
+// eventsFileName 事件流配置文件名，位于arsenal/conf/目录。
+const eventsFileName = "events.json"
+
+const (
+	// Prepared 故障准备完成。
+	Prepared = "prepared"
+	// Injected 故障注入完成。
+	Injected = "injected"
+	// Verified 故障生效校验通过。
+	Verified = "verified"
+	// Removed 故障清理完成。
+	Removed = "removed"
+	// Failed 故障操作失败。
+	Failed = "failed"
+	// Expired 故障的后台进程自行结束，如：周期性闪断次数用完、参数渐变完成。
+	Expired = "expired"
+	// Aborted 故障期间稳态检查失败，故障被自动清理。
+	Aborted = "aborted"
+
+	specVersion = "1.0"
+	typePrefix  = "com.sangfor.arsenal.hardware.fault."
+	sinkTimeout = time.Second
+)
+
+// Config 事件流配置，sink支持：unix:///run/arsenal.sock、fifo:///run/arsenal.fifo、file:///var/log/arsenal-events.log。
+type Config struct {
+	Sink string `json:"sink"`
+}
+
+// Data 事件携带的故障信息。
+type Data struct {
+	FaultType string            `json:"fault-type"`
+	Ops       string            `json:"ops"`
+	Flags     map[string]string `json:"flags"`
+	Options   map[string]string `json:"options,omitempty"`
+	Detail    string            `json:"detail,omitempty"`
+}
+
+// CloudEvent CloudEvents 1.0 JSON格式的事件。
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            *Data     `json:"data"`
+}
+
+var (
+	loadOnce sync.Once
+	config   *Config
+	source   string
+)
+
+// loadConfig 读取事件流配置，配置文件不存在或无效时不输出事件。
+func loadConfig() *Config {
+	loadOnce.Do(func() {
+		hostname, _ := os.Hostname()
+		source = fmt.Sprintf("arsenal-hardware/%s", hostname)
+
+		confDir, err := util.GetArsenalConfDir()
+		if err != nil {
+			return
+		}
+		eventsPath := filepath.Join(confDir, eventsFileName)
+		if !util.FileIsExist(eventsPath) {
+			return
+		}
+		content, err := ioutil.ReadFile(eventsPath)
+		if err != nil {
+			fmt.Printf("warning: read events file(%s) failed(%v)\n", eventsPath, err)
+			return
+		}
+		c := &Config{}
+		if err := json.Unmarshal(content, c); err != nil {
+			fmt.Printf("warning: parse events file(%s) failed(%v)\n", eventsPath, err)
+			return
+		}
+		config = c
+	})
+	return config
+}
+
+// writeSink 向事件接收端写入一行事件，接收端不可用时立即返回错误，不阻塞故障操作。
+func writeSink(sink string, content []byte) error {
+	content = append(content, '\n')
+	switch {
+	case strings.HasPrefix(sink, "unix://"):
+		conn, err := net.DialTimeout("unix", strings.TrimPrefix(sink, "unix://"), sinkTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.SetWriteDeadline(time.Now().Add(sinkTimeout)); err != nil {
+			return err
+		}
+		_, err = conn.Write(content)
+		return err
+	case strings.HasPrefix(sink, "fifo://"):
+		// 非阻塞打开命名管道，没有读端时返回ENXIO。
+		f, err := os.OpenFile(strings.TrimPrefix(sink, "fifo://"), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write(content)
+		return err
+	case strings.HasPrefix(sink, "file://"):
+		f, err := os.OpenFile(strings.TrimPrefix(sink, "file://"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write(content)
+		return err
+	default:
+		return fmt.Errorf("unsupported sink: %s, support: unix://, fifo://, file://", sink)
+	}
+}
+
+// Emit 输出故障生命周期事件，未配置事件流时不做任何操作，输出失败只告警。
+func Emit(eventType string, data *Data) {
+	c := loadConfig()
+	if c == nil || c.Sink == "" {
+		return
+	}
+
+	now := time.Now()
+	e := &CloudEvent{
+		SpecVersion:     specVersion,
+		ID:              fmt.Sprintf("%d-%d", os.Getpid(), now.UnixNano()),
+		Source:          source,
+		Type:            typePrefix + eventType,
+		Subject:         data.FaultType,
+		Time:            now,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	content, err := json.Marshal(e)
+	if err != nil {
+		fmt.Printf("warning: marshal event failed(%v)\n", err)
+		return
+	}
+	if err := writeSink(c.Sink, content); err != nil {
+		fmt.Printf("warning: emit %s event to %s failed(%v)\n", eventType, c.Sink, err)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"arsenal-hardware/util"
+)
+
+// hooksFileName 钩子配置文件名，位于arsenal/conf/目录。
+const hooksFileName = "hooks.json"
+
+const (
+	// PrePrepare 故障准备前执行。
+	PrePrepare = "pre-prepare"
+	// PostInject 故障注入成功后执行。
+	PostInject = "post-inject"
+	// PostRemove 故障清理成功后执行。
+	PostRemove = "post-remove"
+
+	// policyAbort 钩子失败时中止操作。
+	policyAbort = "abort"
+	// policyWarn 钩子失败时只告警。
+	policyWarn = "warn"
+
+	defaultTimeout = 30 * time.Second
+)
+
+// Hook 故障操作前后执行的外部程序，Match为模块名或故障模式名，如：network、network-delay。
+type Hook struct {
+	Name    string   `json:"name"`
+	Match   string   `json:"match"`
+	Stages  []string `json:"stages"`
+	Path    string   `json:"path"`
+	Args    []string `json:"args"`
+	Policy  string   `json:"policy"`
+	Timeout string   `json:"timeout"`
+
+	timeout time.Duration
+}
+
+// Event 传递给钩子的故障信息，同时以环境变量与标准输入JSON两种方式提供。
+type Event struct {
+	Stage     string            `json:"stage"`
+	Ops       string            `json:"ops"`
+	Module    string            `json:"module"`
+	FaultType string            `json:"fault-type"`
+	Flags     map[string]string `json:"flags"`
+	Options   map[string]string `json:"options,omitempty"`
+}
+
+func (h *Hook) init() error {
+	if h.Name == "" {
+		return fmt.Errorf("hook missing name")
+	}
+	if h.Match == "" || h.Path == "" {
+		return fmt.Errorf("hook %s missing match or path", h.Name)
+	}
+	for _, stage := range h.Stages {
+		if stage != PrePrepare && stage != PostInject && stage != PostRemove {
+			return fmt.Errorf("hook %s invalid stage: %s", h.Name, stage)
+		}
+	}
+	switch h.Policy {
+	case "":
+		h.Policy = policyAbort
+	case policyAbort, policyWarn:
+	default:
+		return fmt.Errorf("hook %s invalid policy: %s, support: abort, warn", h.Name, h.Policy)
+	}
+	h.timeout = defaultTimeout
+	if h.Timeout != "" {
+		timeout, err := time.ParseDuration(h.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("hook %s invalid timeout: %s", h.Name, h.Timeout)
+		}
+		h.timeout = timeout
+	}
+	return nil
+}
+
+// Load 读取arsenal/conf/hooks.json钩子配置，文件不存在时返回空。
+func Load() ([]*Hook, error) {
+	confDir, err := util.GetArsenalConfDir()
+	if err != nil {
+		return nil, fmt.Errorf("get arsenal conf dir failed(%v)", err)
+	}
+	hooksPath := filepath.Join(confDir, hooksFileName)
+	if !util.FileIsExist(hooksPath) {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(hooksPath)
+	if err != nil {
+		return nil, fmt.Errorf("read hooks file(%s) failed(%v)", hooksPath, err)
+	}
+	var hooks []*Hook
+	if err := json.Unmarshal(content, &hooks); err != nil {
+		return nil, fmt.Errorf("parse hooks file(%s) failed(%v)", hooksPath, err)
+	}
+	for _, h := range hooks {
+		if err := h.init(); err != nil {
+			return nil, err
+		}
+	}
+	return hooks, nil
+}
+
+func (h *Hook) matches(event *Event) bool {
+	if h.Match != event.Module && h.Match != event.FaultType {
+		return false
+	}
+	for _, stage := range h.Stages {
+		if stage == event.Stage {
+			return true
+		}
+	}
+	return false
+}
+
+// envName 将参数名转换为环境变量名，如：interface转换为ARSENAL_FLAG_INTERFACE。
+func envName(prefix string, name string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// environ 生成钩子的环境变量，参数以ARSENAL_FLAG_前缀提供，框架参数以ARSENAL_OPTION_前缀提供。
+func (e *Event) environ() []string {
+	env := append(os.Environ(),
+		fmt.Sprintf("ARSENAL_STAGE=%s", e.Stage),
+		fmt.Sprintf("ARSENAL_OPS=%s", e.Ops),
+		fmt.Sprintf("ARSENAL_MODULE=%s", e.Module),
+		fmt.Sprintf("ARSENAL_FAULT_TYPE=%s", e.FaultType))
+	names := make([]string, 0, len(e.Flags))
+	for name := range e.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, fmt.Sprintf("%s=%s", envName("ARSENAL_FLAG_", name), e.Flags[name]))
+	}
+	for name, value := range e.Options {
+		env = append(env, fmt.Sprintf("%s=%s", envName("ARSENAL_OPTION_", name), value))
+	}
+	return env
+}
+
+func (h *Hook) run(event *Event, input []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.Path, h.Args...)
+	cmd.Env = event.environ()
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook %s timeout after %s", h.Name, h.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("hook %s failed: %v, output: %s", h.Name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Run 按配置顺序执行与事件匹配的钩子，abort策略的钩子失败时返回错误且不再执行后续钩子，warn策略的钩子失败时只告警。
+func Run(event *Event) error {
+	hooks, err := Load()
+	if err != nil {
+		return err
+	}
+	input, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal hook event failed(%v)", err)
+	}
+
+	for _, h := range hooks {
+		if !h.matches(event) {
+			continue
+		}
+		if err := h.run(event, input); err != nil {
+			if h.Policy == policyAbort {
+				return err
+			}
+			fmt.Printf("warning: %v\n", err)
+		}
+	}
+	return nil
+}
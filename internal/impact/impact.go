@@ -0,0 +1,352 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impact
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moby/sys/mountinfo"
+
+	"arsenal-hardware/util"
+)
+
+// tcpEstablished /proc/net/tcp中ESTABLISHED状态的取值。
+const tcpEstablished = "01"
+
+// Section 爆炸半径中的一类受影响对象。
+type Section struct {
+	Title string
+	Lines []string
+}
+
+// Report 故障目标的爆炸半径。
+type Report struct {
+	Target   string
+	Sections []Section
+}
+
+func (r *Report) add(title string, lines []string) {
+	if len(lines) == 0 {
+		lines = []string{"none"}
+	}
+	r.Sections = append(r.Sections, Section{Title: title, Lines: lines})
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "blast radius of %s:\n", r.Target)
+	for _, section := range r.Sections {
+		fmt.Fprintf(&b, "  %s:\n", section.Title)
+		for _, line := range section.Lines {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Preview 根据故障参数中的目标生成爆炸半径，没有目标参数时返回nil。
+func Preview(flags map[string]string) *Report {
+	if nicDevice, ok := flags["interface"]; ok {
+		return previewInterface(nicDevice)
+	}
+	if disk, ok := flags["device"]; ok {
+		return previewDisk(disk)
+	}
+	if bdf, ok := flags["bdf"]; ok {
+		return previewPcie(bdf)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// diskMounts 获取磁盘及其分区、device mapper设备上的挂载点。
+func diskMounts(disk string) ([]*mountinfo.Info, []*mountinfo.Info) {
+	mounts, err := mountinfo.GetMounts(nil)
+	if err != nil {
+		return nil, nil
+	}
+	var diskMounts []*mountinfo.Info
+	for _, mount := range mounts {
+		// 主设备号为0的是overlay、tmpfs等虚拟文件系统。
+		if mount.Major != 0 && contains(util.DeviceDisks(mount.Major, mount.Minor), disk) {
+			diskMounts = append(diskMounts, mount)
+		}
+	}
+	return mounts, diskMounts
+}
+
+// owningMount 获取路径所在的挂载点，即最长的前缀匹配。
+func owningMount(path string, mounts []*mountinfo.Info) *mountinfo.Info {
+	var owner *mountinfo.Info
+	for _, mount := range mounts {
+		prefix := strings.TrimSuffix(mount.Mountpoint, "/") + "/"
+		if path != mount.Mountpoint && !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if owner == nil || len(mount.Mountpoint) > len(owner.Mountpoint) {
+			owner = mount
+		}
+	}
+	return owner
+}
+
+// processOpenFiles 统计每个进程打开的位于磁盘上的文件数，包括磁盘设备文件本身。
+func processOpenFiles(disk string, mounts []*mountinfo.Info, diskMounts []*mountinfo.Info) []string {
+	onDisk := func(path string) bool {
+		if strings.HasPrefix(path, "/dev/") {
+			return contains(util.BlockDisks(filepath.Base(path)), disk)
+		}
+		owner := owningMount(path, mounts)
+		for _, mount := range diskMounts {
+			if owner == mount {
+				return true
+			}
+		}
+		return false
+	}
+
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, proc := range procs {
+		if pid, err := strconv.Atoi(proc.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	var lines []string
+	for _, pid := range pids {
+		procPath := filepath.Join("/proc", strconv.Itoa(pid))
+		// 内核线程没有命令行，不持有文件。
+		if cmdline, err := ioutil.ReadFile(filepath.Join(procPath, "cmdline")); err != nil || len(cmdline) == 0 {
+			continue
+		}
+		var count int
+		if cwd, err := os.Readlink(filepath.Join(procPath, "cwd")); err == nil && onDisk(cwd) {
+			count++
+		}
+		fds, _ := ioutil.ReadDir(filepath.Join(procPath, "fd"))
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(procPath, "fd", fd.Name()))
+			if err == nil && strings.HasPrefix(target, "/") && onDisk(target) {
+				count++
+			}
+		}
+		if count != 0 {
+			comm, _ := ioutil.ReadFile(filepath.Join(procPath, "comm"))
+			lines = append(lines, fmt.Sprintf("pid %d (%s): %d open files", pid, strings.TrimSpace(string(comm)), count))
+		}
+	}
+	return lines
+}
+
+func previewDisk(disk string) *Report {
+	r := &Report{Target: fmt.Sprintf("device %s", disk)}
+	mounts, diskMounts := diskMounts(disk)
+	var mountLines []string
+	for _, mount := range diskMounts {
+		mountLines = append(mountLines, fmt.Sprintf("%s on %s type %s", mount.Source, mount.Mountpoint, mount.FSType))
+	}
+	r.add("mounted filesystems", mountLines)
+	r.add("processes holding files open", processOpenFiles(disk, mounts, diskMounts))
+	return r
+}
+
+// parseHexIPv4 解析/proc/net中小端序的十六进制IPv4地址。
+func parseHexIPv4(value string) net.IP {
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != net.IPv4len {
+		return nil
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+	return ip
+}
+
+// parseHexIP 解析/proc/net/tcp与tcp6中的地址，IPv6地址按4字节分组小端序存储。
+func parseHexIP(value string) net.IP {
+	if len(value) == 2*net.IPv4len {
+		return parseHexIPv4(value)
+	}
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != net.IPv6len {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	for i := 0; i < net.IPv6len; i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	return ip
+}
+
+// parseHexEndpoint 解析/proc/net/tcp中的地址与端口，如：0100007F:0016。
+func parseHexEndpoint(value string) string {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return value
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	ip := parseHexIP(parts[0])
+	if err != nil || ip == nil {
+		return value
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10))
+}
+
+// interfaceRoutes 获取网卡上的IPv4与IPv6路由。
+func interfaceRoutes(nicDevice string) []string {
+	var routes []string
+	// Iface	Destination	Gateway	Flags	RefCnt	Use	Metric	Mask	MTU	Window	IRTT
+	const ipv4Fields, ipv4Dest, ipv4Gateway, ipv4Mask = 8, 1, 2, 7
+	for _, fields := range util.ReadProcTable("/proc/net/route") {
+		if len(fields) < ipv4Fields || fields[0] != nicDevice {
+			continue
+		}
+		dest, gateway, mask := parseHexIPv4(fields[ipv4Dest]), parseHexIPv4(fields[ipv4Gateway]), parseHexIPv4(fields[ipv4Mask])
+		if dest == nil || gateway == nil || mask == nil {
+			continue
+		}
+		prefixLen, _ := net.IPMask(mask).Size()
+		route := fmt.Sprintf("%s/%d", dest, prefixLen)
+		if !gateway.IsUnspecified() {
+			route = fmt.Sprintf("%s via %s", route, gateway)
+		}
+		routes = append(routes, route)
+	}
+
+	// 目的地址、前缀长度、源地址、源前缀长度、下一跳、metric、引用计数、使用计数、标志、网卡。
+	const ipv6Fields, ipv6PrefixLen, ipv6NextHop = 10, 1, 4
+	for _, fields := range util.ReadProcTable("/proc/net/ipv6_route") {
+		if len(fields) < ipv6Fields || fields[ipv6Fields-1] != nicDevice {
+			continue
+		}
+		dest, err := hex.DecodeString(fields[0])
+		nextHop, nextHopErr := hex.DecodeString(fields[ipv6NextHop])
+		prefixLen, prefixErr := strconv.ParseUint(fields[ipv6PrefixLen], 16, 8)
+		if err != nil || nextHopErr != nil || prefixErr != nil {
+			continue
+		}
+		route := fmt.Sprintf("%s/%d", net.IP(dest), prefixLen)
+		if !net.IP(nextHop).IsUnspecified() {
+			route = fmt.Sprintf("%s via %s", route, net.IP(nextHop))
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// establishedConnections 获取本端地址属于网卡的已建立TCP连接。
+func establishedConnections(addrs []net.IP) []string {
+	// sl local_address rem_address st ...
+	const tcpFields, tcpLocal, tcpRemote, tcpState = 4, 1, 2, 3
+	var connections []string
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		for _, fields := range util.ReadProcTable(path) {
+			if len(fields) < tcpFields || fields[tcpState] != tcpEstablished {
+				continue
+			}
+			local := parseHexIP(strings.Split(fields[tcpLocal], ":")[0])
+			for _, addr := range addrs {
+				if local != nil && local.Equal(addr) {
+					connections = append(connections, fmt.Sprintf("%s -> %s",
+						parseHexEndpoint(fields[tcpLocal]), parseHexEndpoint(fields[tcpRemote])))
+					break
+				}
+			}
+		}
+	}
+	return connections
+}
+
+func previewInterface(nicDevice string) *Report {
+	r := &Report{Target: fmt.Sprintf("interface %s", nicDevice)}
+	var addrLines []string
+	var addrs []net.IP
+	if nic, err := net.InterfaceByName(nicDevice); err == nil {
+		nicAddrs, _ := nic.Addrs()
+		for _, addr := range nicAddrs {
+			addrLines = append(addrLines, addr.String())
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				addrs = append(addrs, ipNet.IP)
+			}
+		}
+	}
+	r.add("addresses", addrLines)
+	r.add("routes", interfaceRoutes(nicDevice))
+	r.add("established tcp connections", establishedConnections(addrs))
+	return r
+}
+
+// classDevices 获取/sys/class下挂在pcie设备之下的设备，如：网卡、磁盘。
+func classDevices(classPath string, bdf string) []string {
+	entries, err := ioutil.ReadDir(classPath)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if contains(util.PcieAncestors(filepath.Join(classPath, entry.Name(), "device")), bdf) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func previewPcie(bdf string) *Report {
+	r := &Report{Target: fmt.Sprintf("bdf %s", bdf)}
+	devicePath := filepath.Join("/sys/bus/pci/devices", bdf)
+
+	var driverLines []string
+	if driverPath, err := os.Readlink(filepath.Join(devicePath, "driver")); err == nil {
+		driverLines = append(driverLines, filepath.Base(driverPath))
+	}
+	r.add("bound driver", driverLines)
+
+	var children []string
+	entries, _ := ioutil.ReadDir("/sys/bus/pci/devices")
+	for _, entry := range entries {
+		ancestors := util.PcieAncestors(filepath.Join("/sys/bus/pci/devices", entry.Name()))
+		if entry.Name() != bdf && contains(ancestors, bdf) {
+			children = append(children, entry.Name())
+		}
+	}
+	sort.Strings(children)
+	r.add("child devices", children)
+	r.add("net devices", classDevices("/sys/class/net", bdf))
+	r.add("block devices", classDevices("/sys/block", bdf))
+	return r
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"arsenal-hardware/util"
+)
+
+// policyFileName 策略文件名，位于arsenal/conf/目录。
+const policyFileName = "hardware-policy.json"
+
+// Policy 故障注入安全策略，描述受保护的目标以及爆炸半径限制。
+type Policy struct {
+	ProtectedInterfaces  []string `json:"protected-interfaces"`
+	ProtectedDevices     []string `json:"protected-devices"`
+	ProtectedMountPoints []string `json:"protected-mount-points"`
+	ProtectedBDFs        []string `json:"protected-bdfs"`
+	// MaxFaults 同时处于注入状态的故障数量上限，0表示不限制。
+	MaxFaults int `json:"max-faults"`
+
+	// protected 受保护目标与保护原因，key示例：interface/eth0。
+	protected map[string]string
+}
+
+// Load 读取arsenal/conf/hardware-policy.json策略文件，并自动保护默认路由网卡与根分区所在磁盘。
+func Load() (*Policy, error) {
+	p := &Policy{}
+	confDir, err := util.GetArsenalConfDir()
+	if err != nil {
+		return nil, fmt.Errorf("get arsenal conf dir failed(%v)", err)
+	}
+	policyPath := filepath.Join(confDir, policyFileName)
+	if util.FileIsExist(policyPath) {
+		content, err := ioutil.ReadFile(policyPath)
+		if err != nil {
+			return nil, fmt.Errorf("read policy file(%s) failed(%v)", policyPath, err)
+		}
+		if err := json.Unmarshal(content, p); err != nil {
+			return nil, fmt.Errorf("parse policy file(%s) failed(%v)", policyPath, err)
+		}
+	}
+	p.buildProtected()
+	return p, nil
+}
+
+func (p *Policy) protect(kind string, name string, reason string) {
+	key := fmt.Sprintf("%s/%s", kind, name)
+	if _, ok := p.protected[key]; !ok {
+		p.protected[key] = reason
+	}
+}
+
+// protectDisk 保护磁盘以及其到pcie root bus路径上的所有pcie设备。
+func (p *Policy) protectDisk(disk string, reason string) {
+	p.protect("device", disk, reason)
+	for _, bdf := range util.PcieAncestors(filepath.Join(sysfsRoot, "block", disk, "device")) {
+		p.protect("bdf", bdf, fmt.Sprintf("backs block device %s", disk))
+	}
+}
+
+// protectInterface 保护网卡以及其到pcie root bus路径上的所有pcie设备。
+func (p *Policy) protectInterface(nicDevice string, reason string) {
+	p.protect("interface", nicDevice, reason)
+	for _, bdf := range util.PcieAncestors(filepath.Join(sysfsRoot, "class/net", nicDevice, "device")) {
+		p.protect("bdf", bdf, fmt.Sprintf("backs interface %s", nicDevice))
+	}
+}
+
+// protectListed 保护策略文件中列出的网卡、磁盘与pcie设备。
+func (p *Policy) protectListed() {
+	for _, nicDevice := range p.ProtectedInterfaces {
+		p.protectInterface(nicDevice, "listed in policy file")
+	}
+	for _, disk := range p.ProtectedDevices {
+		p.protectDisk(disk, "listed in policy file")
+	}
+	for _, bdf := range p.ProtectedBDFs {
+		p.protect("bdf", bdf, "listed in policy file")
+	}
+}
+
+func (p *Policy) buildProtected() {
+	p.protected = make(map[string]string)
+	p.protectListed()
+	for _, nicDevice := range defaultRouteInterfaces() {
+		p.protectInterface(nicDevice, "carries the default route")
+	}
+	mountPoints := append([]string{"/"}, p.ProtectedMountPoints...)
+	for _, mountPoint := range mountPoints {
+		for _, disk := range mountPointDisks(mountPoint) {
+			p.protectDisk(disk, fmt.Sprintf("holds mount point %s", mountPoint))
+		}
+	}
+}
+
+// Check 检查故障目标与当前已注入故障数量是否违反策略，newFaults为本次将要注入的故障数量。
+func (p *Policy) Check(flags map[string]string, activeFaults int, newFaults int) error {
+	var violations []string
+	for _, kind := range []string{"interface", "device", "bdf"} {
+		name, ok := flags[kind]
+		if !ok {
+			continue
+		}
+		if reason, ok := p.protected[fmt.Sprintf("%s/%s", kind, name)]; ok {
+			violations = append(violations, fmt.Sprintf("%s %s is protected: %s", kind, name, reason))
+		}
+	}
+
+	if p.MaxFaults > 0 && activeFaults+newFaults > p.MaxFaults {
+		violations = append(violations, fmt.Sprintf("max-faults %d exceeded, %d faults already injected",
+			p.MaxFaults, activeFaults))
+	}
+
+	if len(violations) != 0 {
+		return fmt.Errorf("policy violation: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
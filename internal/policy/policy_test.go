@@ -0,0 +1,177 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSysfs 构造网卡eth1与磁盘sda的sysfs链接：
+// eth1 -> 0000:00:1c.0/0000:03:00.0，sda -> 0000:00:17.0上的sata控制器。
+func fakeSysfs(t *testing.T) {
+	root := t.TempDir()
+	links := map[string]string{
+		"class/net/eth1/device": "devices/pci0000:00/0000:00:1c.0/0000:03:00.0",
+		"block/sda/device":      "devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0",
+		"class/net/veth0":       "devices/virtual/net/veth0",
+	}
+	for link, target := range links {
+		targetPath := filepath.Join(root, target)
+		linkPath := filepath.Join(root, link)
+		if err := os.MkdirAll(targetPath, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(linkPath), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(targetPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := sysfsRoot
+	sysfsRoot = root
+	t.Cleanup(func() { sysfsRoot = previous })
+}
+
+func TestProtectedAncestors(t *testing.T) {
+	fakeSysfs(t)
+	p := &Policy{protected: make(map[string]string)}
+	p.protectInterface("eth1", "carries the default route")
+	p.protectDisk("sda", "holds mount point /")
+	p.protectInterface("veth0", "listed in policy file")
+
+	want := map[string]string{
+		"interface/eth1":   "carries the default route",
+		"bdf/0000:00:1c.0": "backs interface eth1",
+		"bdf/0000:03:00.0": "backs interface eth1",
+		"device/sda":       "holds mount point /",
+		"bdf/0000:00:17.0": "backs block device sda",
+		"interface/veth0":  "listed in policy file",
+	}
+	if len(p.protected) != len(want) {
+		t.Errorf("got %d protected targets %v, want %d", len(p.protected), p.protected, len(want))
+	}
+	for key, reason := range want {
+		if p.protected[key] != reason {
+			t.Errorf("protected[%s] = %q, want %q", key, p.protected[key], reason)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fakeSysfs(t)
+	tests := []struct {
+		name         string
+		policy       *Policy
+		flags        map[string]string
+		activeFaults int
+		newFaults    int
+		wantErr      []string
+	}{
+		{
+			name:   "unprotected interface",
+			policy: &Policy{ProtectedInterfaces: []string{"eth1"}},
+			flags:  map[string]string{"interface": "eth2"},
+		},
+		{
+			name:    "protected interface",
+			policy:  &Policy{ProtectedInterfaces: []string{"eth1"}},
+			flags:   map[string]string{"interface": "eth1"},
+			wantErr: []string{"interface eth1 is protected: listed in policy file"},
+		},
+		{
+			name:    "pcie switch above protected interface",
+			policy:  &Policy{ProtectedInterfaces: []string{"eth1"}},
+			flags:   map[string]string{"bdf": "0000:00:1c.0"},
+			wantErr: []string{"bdf 0000:00:1c.0 is protected: backs interface eth1"},
+		},
+		{
+			name:    "controller of protected disk",
+			policy:  &Policy{ProtectedDevices: []string{"sda"}},
+			flags:   map[string]string{"bdf": "0000:00:17.0"},
+			wantErr: []string{"bdf 0000:00:17.0 is protected: backs block device sda"},
+		},
+		{
+			name:    "protected disk",
+			policy:  &Policy{ProtectedDevices: []string{"sda"}},
+			flags:   map[string]string{"device": "sda"},
+			wantErr: []string{"device sda is protected: listed in policy file"},
+		},
+		{
+			name:    "protected bdf",
+			policy:  &Policy{ProtectedBDFs: []string{"0000:5e:00.0"}},
+			flags:   map[string]string{"bdf": "0000:5e:00.0"},
+			wantErr: []string{"bdf 0000:5e:00.0 is protected: listed in policy file"},
+		},
+		{
+			name:         "under max faults",
+			policy:       &Policy{MaxFaults: 3},
+			flags:        map[string]string{"interface": "eth2"},
+			activeFaults: 1,
+			newFaults:    2,
+		},
+		{
+			name:         "max faults exceeded",
+			policy:       &Policy{MaxFaults: 3},
+			flags:        map[string]string{"interface": "eth2"},
+			activeFaults: 2,
+			newFaults:    2,
+			wantErr:      []string{"max-faults 3 exceeded, 2 faults already injected"},
+		},
+		{
+			name:         "unlimited",
+			policy:       &Policy{},
+			flags:        map[string]string{"interface": "eth2"},
+			activeFaults: 100,
+			newFaults:    1,
+		},
+		{
+			name:         "several violations",
+			policy:       &Policy{ProtectedInterfaces: []string{"eth1"}, MaxFaults: 1},
+			flags:        map[string]string{"interface": "eth1"},
+			activeFaults: 1,
+			newFaults:    1,
+			wantErr:      []string{"interface eth1 is protected", "; max-faults 1 exceeded"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.policy
+			p.protected = make(map[string]string)
+			p.protectListed()
+
+			err := p.Check(tt.flags, tt.activeFaults, tt.newFaults)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Check() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), "policy violation: ") {
+				t.Fatalf("Check() error = %v, want policy violation", err)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Check() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"strings"
+
+	"github.com/moby/sys/mountinfo"
+
+	"arsenal-hardware/util"
+)
+
+// sysfsRoot sysfs的挂载点，用于解析目标到pcie root bus路径上的设备。
+var sysfsRoot = "/sys"
+
+// defaultRouteInterfaces 解析/proc/net/route与/proc/net/ipv6_route，获取承载默认路由的网卡。
+func defaultRouteInterfaces() []string {
+	var nicDevices []string
+
+	// Iface	Destination	Gateway	Flags	RefCnt	Use	Metric	Mask	MTU	Window	IRTT
+	// eth0	00000000	0102A8C0	0003	0	0	100	00000000	0	0	0
+	const ipv4Fields, ipv4Dest, ipv4Mask = 8, 1, 7
+	for _, fields := range util.ReadProcTable("/proc/net/route") {
+		if len(fields) >= ipv4Fields && fields[ipv4Dest] == "00000000" && fields[ipv4Mask] == "00000000" {
+			nicDevices = append(nicDevices, fields[0])
+		}
+	}
+
+	// 目的地址、前缀长度、源地址、源前缀长度、下一跳、metric、引用计数、使用计数、标志、网卡。
+	const ipv6Fields, ipv6PrefixLen = 10, 1
+	zeroAddr := strings.Repeat("0", 32)
+	for _, fields := range util.ReadProcTable("/proc/net/ipv6_route") {
+		if len(fields) >= ipv6Fields && fields[0] == zeroAddr && fields[ipv6PrefixLen] == "00" &&
+			fields[ipv6Fields-1] != "lo" {
+			nicDevices = append(nicDevices, fields[ipv6Fields-1])
+		}
+	}
+	return nicDevices
+}
+
+// mountPointDisks 获取挂载点所在的物理磁盘，分区与device mapper设备会解析到底层磁盘。
+func mountPointDisks(mountPoint string) []string {
+	mounts, err := mountinfo.GetMounts(mountinfo.SingleEntryFilter(mountPoint))
+	if err != nil || len(mounts) == 0 {
+		return nil
+	}
+	// 主设备号为0的是overlay、tmpfs等虚拟文件系统。
+	if mounts[0].Major == 0 {
+		return nil
+	}
+	return util.DeviceDisks(mounts[0].Major, mounts[0].Minor)
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"arsenal-hardware/util"
+)
+
+const (
+	// Before 故障注入前检查，失败时不注入故障。
+	Before = "before"
+	// During 故障注入期间周期检查，超过阈值时立即清理故障。
+	During = "during"
+	// After 故障清理后检查。
+	After = "after"
+
+	typeCommand = "command"
+	typeHTTP    = "http"
+
+	defaultTimeout          = 5 * time.Second
+	defaultInterval         = 5 * time.Second
+	defaultFailureThreshold = 1
+)
+
+// Probe 稳态探针，支持执行命令与访问本机HTTP接口两种方式。
+type Probe struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	URL     string `json:"url"`
+	// ExpectStatus HTTP探针期望的状态码，默认200。
+	ExpectStatus int `json:"expect-status"`
+	// Timeout 单次检查超时时间，默认5s。
+	Timeout string `json:"timeout"`
+	// MaxLatency 单次检查耗时超过该值同样视为失败，为空时不检查。
+	MaxLatency string `json:"max-latency"`
+	// Phases 检查阶段，取值为before、during、after。
+	Phases []string `json:"phases"`
+	// Interval 故障期间的检查间隔，默认5s。
+	Interval string `json:"interval"`
+	// FailureThreshold 故障期间连续失败次数达到该值时中止故障，默认1。
+	FailureThreshold int `json:"failure-threshold"`
+
+	timeout    time.Duration
+	maxLatency time.Duration
+	interval   time.Duration
+}
+
+// Result 探针检查结果。
+type Result struct {
+	Name    string        `json:"name"`
+	Phase   string        `json:"phase"`
+	Passed  bool          `json:"passed"`
+	Detail  string        `json:"detail"`
+	Latency time.Duration `json:"latency"`
+	Time    time.Time     `json:"time"`
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("probe %s %s: %s", r.Name, r.Phase, r.Detail)
+}
+
+func parseDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return duration, nil
+}
+
+// isLocalhost HTTP探针只允许访问本机地址。
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (p *Probe) init() error {
+	var err error
+	if p.Name == "" {
+		return fmt.Errorf("probe missing name")
+	}
+	switch p.Type {
+	case typeCommand:
+		if p.Command == "" {
+			return fmt.Errorf("probe %s missing command", p.Name)
+		}
+	case typeHTTP:
+		u, err := url.Parse(p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("probe %s invalid url: %s", p.Name, p.URL)
+		}
+		if !isLocalhost(u.Hostname()) {
+			return fmt.Errorf("probe %s only support localhost url: %s", p.Name, p.URL)
+		}
+		if p.ExpectStatus == 0 {
+			p.ExpectStatus = http.StatusOK
+		}
+	default:
+		return fmt.Errorf("probe %s unsupported type: %s, support: command, http", p.Name, p.Type)
+	}
+
+	for _, phase := range p.Phases {
+		if phase != Before && phase != During && phase != After {
+			return fmt.Errorf("probe %s invalid phase: %s", p.Name, phase)
+		}
+	}
+	if p.timeout, err = parseDuration(p.Timeout, defaultTimeout); err != nil {
+		return fmt.Errorf("probe %s %v", p.Name, err)
+	}
+	if p.maxLatency, err = parseDuration(p.MaxLatency, 0); err != nil {
+		return fmt.Errorf("probe %s %v", p.Name, err)
+	}
+	if p.interval, err = parseDuration(p.Interval, defaultInterval); err != nil || p.interval == 0 {
+		return fmt.Errorf("probe %s invalid interval: %s", p.Name, p.Interval)
+	}
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = defaultFailureThreshold
+	}
+	return nil
+}
+
+// Load 读取JSON格式的探针配置文件。
+func Load(path string) ([]*Probe, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read probes file(%s) failed(%v)", path, err)
+	}
+	var probes []*Probe
+	if err := json.Unmarshal(content, &probes); err != nil {
+		return nil, fmt.Errorf("parse probes file(%s) failed(%v)", path, err)
+	}
+	for _, p := range probes {
+		if err := p.init(); err != nil {
+			return nil, err
+		}
+	}
+	return probes, nil
+}
+
+// HasPhase 判断探针是否需要在指定阶段检查。
+func (p *Probe) HasPhase(phase string) bool {
+	for _, value := range p.Phases {
+		if value == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Probe) check() (string, error) {
+	switch p.Type {
+	case typeCommand:
+		timeoutSeconds := uint64(math.Ceil(p.timeout.Seconds()))
+		result, err := util.ExecCommandBlock(p.Command, timeoutSeconds)
+		if err != nil {
+			return "", fmt.Errorf("execute: %s failed: %v, result: %s", p.Command, err, strings.TrimSpace(result))
+		}
+		return fmt.Sprintf("execute: %s succeeded", p.Command), nil
+	default:
+		client := http.Client{Timeout: p.timeout}
+		resp, err := client.Get(p.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != p.ExpectStatus {
+			return "", fmt.Errorf("get %s status %d, expect %d", p.URL, resp.StatusCode, p.ExpectStatus)
+		}
+		return fmt.Sprintf("get %s status %d", p.URL, resp.StatusCode), nil
+	}
+}
+
+// Run 执行一次检查。
+func (p *Probe) Run(phase string) Result {
+	start := time.Now()
+	detail, err := p.check()
+	result := Result{Name: p.Name, Phase: phase, Latency: time.Since(start), Time: start}
+	switch {
+	case err != nil:
+		result.Detail = err.Error()
+	case p.maxLatency > 0 && result.Latency > p.maxLatency:
+		result.Detail = fmt.Sprintf("latency %s exceeds max-latency %s", result.Latency, p.maxLatency)
+	default:
+		result.Passed = true
+		result.Detail = detail
+	}
+	return result
+}
+
+// RunPhase 执行指定阶段的所有探针，存在失败的探针时返回错误。
+func RunPhase(probes []*Probe, phase string) ([]Result, error) {
+	var results []Result
+	var failures []string
+	for _, p := range probes {
+		if !p.HasPhase(phase) {
+			continue
+		}
+		result := p.Run(phase)
+		results = append(results, result)
+		if !result.Passed {
+			failures = append(failures, result.String())
+		}
+	}
+	if len(failures) != 0 {
+		return results, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return results, nil
+}
+
+// Watch 按各探针的间隔持续检查，某个探针连续失败次数达到阈值时返回该次失败结果，stop被关闭时返回nil。
+func Watch(probes []*Probe, stop <-chan struct{}) *Result {
+	failed := make(chan Result, len(probes))
+	done := make(chan struct{})
+	defer close(done)
+
+	for _, p := range probes {
+		if !p.HasPhase(During) {
+			continue
+		}
+		go func(p *Probe) {
+			ticker := time.NewTicker(p.interval)
+			defer ticker.Stop()
+			var failures int
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+				result := p.Run(During)
+				if result.Passed {
+					failures = 0
+					continue
+				}
+				failures++
+				fmt.Printf("%s %s, %d/%d\n", time.Now().Format(time.RFC3339), result, failures, p.FailureThreshold)
+				if failures >= p.FailureThreshold {
+					failed <- result
+					return
+				}
+			}
+		}(p)
+	}
+
+	select {
+	case <-stop:
+		return nil
+	case result := <-failed:
+		return &result
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"arsenal-hardware/internal/audit"
+	"arsenal-hardware/internal/parse"
+	"arsenal-hardware/internal/state"
+	"arsenal-hardware/submodules"
+)
+
+func init() {
+	submodules.AddCommand("report", report)
+}
+
+// reportData 报告内容，包括审计日志中的操作时间线与时间范围内的故障状态记录。
+type reportData struct {
+	Generated time.Time
+	Since     time.Time
+	Timeline  []*audit.Entry
+	Faults    []*state.Record
+}
+
+// formatFlags 按参数名排序输出参数，如：--delay 10ms --interface eth1。
+func formatFlags(flags map[string]string) string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("--%s %s", name, flags[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// escapeCell 转义Markdown表格单元格中的竖线与换行。
+func escapeCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(value, "\n", " ")
+}
+
+var funcs = map[string]interface{}{
+	"flags": formatFlags,
+	"time":  formatTime,
+	"cell":  escapeCell,
+}
+
+const markdownTemplate = `# Fault Injection Report
+
+Generated: {{time .Generated}}, since: {{time .Since}}
+
+## Timeline
+
+{{if .Timeline}}| Time | Operation | Fault | Parameters | Result |
+| --- | --- | --- | --- | --- |
+{{range .Timeline}}| {{time .Time}} | {{.Ops}} | {{.FaultType}} | {{cell (flags .Flags)}} | {{if .Succeeded}}succeeded{{else}}failed: {{cell .Error}}{{end}} |
+{{end}}{{else}}No operations.
+{{end}}
+## Faults
+{{range .Faults}}
+### {{.ID}}
+
+- Status: {{.Status}}
+- Injected: {{time .InjectTime}}
+- Removed: {{time .RemoveTime}}
+- Parameters: ` + "`{{flags .Flags}}`" + `
+{{- if .Options}}
+- Options: ` + "`{{flags .Options}}`" + `{{end}}
+{{- if .Verification}}
+- Verification: {{if .Verification.Passed}}passed{{else}}failed{{end}}, {{.Verification.Detail}}{{end}}
+{{- if .AbortReason}}
+- Aborted: {{.AbortReason}}{{end}}
+{{- range .Workers}}{{if .Error}}
+- Worker {{.Mode}} error: {{.Error}}{{end}}{{end}}
+{{if .ProbeResults}}
+| Probe | Phase | Result | Latency | Detail |
+| --- | --- | --- | --- | --- |
+{{range .ProbeResults}}| {{.Name}} | {{.Phase}} | {{if .Passed}}passed{{else}}failed{{end}} | {{.Latency}} | {{cell .Detail}} |
+{{end}}{{end}}
+{{- if .RestoreLeaks}}
+Restore leaks:
+
+{{range .RestoreLeaks}}- {{.}}
+{{end}}{{end}}{{else}}
+No faults.
+{{end}}`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Fault Injection Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.failed { color: #c00; }
+.passed { color: #080; }
+</style>
+</head>
+<body>
+<h1>Fault Injection Report</h1>
+<p>Generated: {{time .Generated}}, since: {{time .Since}}</p>
+<h2>Timeline</h2>
+{{if .Timeline}}<table>
+<tr><th>Time</th><th>Operation</th><th>Fault</th><th>Parameters</th><th>Result</th></tr>
+{{range .Timeline}}<tr><td>{{time .Time}}</td><td>{{.Ops}}</td><td>{{.FaultType}}</td><td><code>{{flags .Flags}}</code></td>
+{{if .Succeeded}}<td class="passed">succeeded</td>{{else}}<td class="failed">failed: {{.Error}}</td>{{end}}</tr>
+{{end}}</table>
+{{else}}<p>No operations.</p>
+{{end}}<h2>Faults</h2>
+{{range .Faults}}<h3>{{.ID}}</h3>
+<ul>
+<li>Status: {{.Status}}</li>
+<li>Injected: {{time .InjectTime}}</li>
+<li>Removed: {{time .RemoveTime}}</li>
+<li>Parameters: <code>{{flags .Flags}}</code></li>
+{{if .Options}}<li>Options: <code>{{flags .Options}}</code></li>
+{{end}}{{if .Verification}}<li>Verification: {{if .Verification.Passed}}<span class="passed">passed</span>{{else}}<span class="failed">failed</span>{{end}}, {{.Verification.Detail}}</li>
+{{end}}{{if .AbortReason}}<li class="failed">Aborted: {{.AbortReason}}</li>
+{{end}}{{range .Workers}}{{if .Error}}<li class="failed">Worker {{.Mode}} error: {{.Error}}</li>
+{{end}}{{end}}</ul>
+{{if .ProbeResults}}<table>
+<tr><th>Probe</th><th>Phase</th><th>Result</th><th>Latency</th><th>Detail</th></tr>
+{{range .ProbeResults}}<tr><td>{{.Name}}</td><td>{{.Phase}}</td>{{if .Passed}}<td class="passed">passed</td>{{else}}<td class="failed">failed</td>{{end}}<td>{{.Latency}}</td><td>{{.Detail}}</td></tr>
+{{end}}</table>
+{{end}}{{if .RestoreLeaks}}<p class="failed">Restore leaks:</p>
+<ul>
+{{range .RestoreLeaks}}<li>{{.String}}</li>
+{{end}}</ul>
+{{end}}{{else}}<p>No faults.</p>
+{{end}}</body>
+</html>
+`
+
+// parseSince 解析报告起始时间，支持RFC3339时间或相对当前的时长，如：2h，为空时包含全部记录。
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since: %s, use RFC3339 time or duration", value)
+	}
+	return since, nil
+}
+
+// collectData 读取时间范围内的审计记录与故障状态记录，故障在时间范围内仍处于注入状态或被清理时纳入报告。
+func collectData(since time.Time) (*reportData, error) {
+	entries, err := audit.List(since)
+	if err != nil {
+		return nil, err
+	}
+	records, err := state.List()
+	if err != nil {
+		return nil, fmt.Errorf("list fault states failed(%v)", err)
+	}
+
+	data := &reportData{Generated: time.Now(), Since: since, Timeline: entries}
+	for _, record := range records {
+		if record.Status == state.Injected || !record.RemoveTime.Before(since) {
+			data.Faults = append(data.Faults, record)
+		}
+	}
+	return data, nil
+}
+
+func render(w io.Writer, format string, data *reportData) error {
+	switch format {
+	case "", "markdown":
+		t := template.Must(template.New("report").Funcs(funcs).Parse(markdownTemplate))
+		return t.Execute(w, data)
+	case "html":
+		t := htmltemplate.Must(htmltemplate.New("report").Funcs(funcs).Parse(htmlTemplate))
+		return t.Execute(w, data)
+	default:
+		return fmt.Errorf("unsupported report format: %s, support: markdown, html", format)
+	}
+}
+
+// report 根据审计日志与故障状态生成实验报告：
+// arsenal-hardware report --format markdown|html --since 2h --output report.md，未指定--output时输出到标准输出。
+func report(inputArgs []string) error {
+	flags := parse.TransInputFlagsToMap(inputArgs)
+	since, err := parseSince(flags["since"])
+	if err != nil {
+		return err
+	}
+	data, err := collectData(since)
+	if err != nil {
+		return err
+	}
+
+	// 先生成完整的报告，格式不支持或生成失败时不会覆盖已有的报告文件。
+	var content bytes.Buffer
+	if err := render(&content, flags["format"], data); err != nil {
+		return err
+	}
+	outputPath, ok := flags["output"]
+	if !ok {
+		_, err := content.WriteTo(os.Stdout)
+		return err
+	}
+	if err := ioutil.WriteFile(outputPath, content.Bytes(), 0640); err != nil {
+		return fmt.Errorf("write report(%s) failed(%v)", outputPath, err)
+	}
+	fmt.Printf("report saved: %s\n", outputPath)
+	return nil
+}
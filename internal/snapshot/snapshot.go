@@ -0,0 +1,230 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"arsenal-hardware/util"
+)
+
+const (
+	// sectionTc 各网卡的tc qdisc与filter。
+	sectionTc = "tc"
+	// sectionIptables iptables-save输出。
+	sectionIptables = "iptables"
+	// sectionLink 各网卡的up/down状态。
+	sectionLink = "link"
+	// sectionDisk 各磁盘的device/state。
+	sectionDisk = "disk"
+	// sectionPcie pcie设备及其绑定的驱动。
+	sectionPcie = "pcie"
+)
+
+var (
+	iptablesCounterRegexp   = regexp.MustCompile(`\[\d+:\d+\]`)
+	iptablesInterfaceRegexp = regexp.MustCompile(`-[io] (\S+)`)
+)
+
+// Snapshot 主机环境快照，key为快照项，value为有序的快照条目。
+// 除iptables外，每个条目都以所属的网卡、磁盘或pcie设备名开头。
+type Snapshot map[string][]string
+
+// Leak 故障清理后与注入前快照不一致的条目。
+type Leak struct {
+	Section string `json:"section"`
+	// Subject 条目所属的网卡、磁盘或pcie设备，无法确定时为空。
+	Subject string `json:"subject"`
+	// Change 取值为added或missing。
+	Change string `json:"change"`
+	Line   string `json:"line"`
+}
+
+func (l Leak) String() string {
+	return fmt.Sprintf("%s %s: %s", l.Section, l.Change, l.Line)
+}
+
+// Capture 采集主机环境快照，缺少依赖命令的快照项会被跳过。
+func Capture() Snapshot {
+	s := make(Snapshot)
+	if _, isMissCmd := util.CheckEnvShellCommand([]string{"tc"}); !isMissCmd {
+		s[sectionTc] = captureTc()
+	}
+	if _, isMissCmd := util.CheckEnvShellCommand([]string{"iptables-save"}); !isMissCmd {
+		s[sectionIptables] = captureIptables()
+	}
+	s[sectionLink] = captureLink()
+	s[sectionDisk] = captureDisk()
+	s[sectionPcie] = capturePcie()
+	return s
+}
+
+func prefixLines(prefix string, content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", prefix, strings.TrimSpace(line)))
+	}
+	return lines
+}
+
+func captureTc() []string {
+	var lines []string
+	nics, err := net.Interfaces()
+	if err != nil {
+		return lines
+	}
+	for _, nic := range nics {
+		shellCommands := []string{
+			fmt.Sprintf("tc qdisc show dev %s", nic.Name),
+			fmt.Sprintf("tc class show dev %s", nic.Name),
+			fmt.Sprintf("tc filter show dev %s", nic.Name),
+			fmt.Sprintf("tc filter show dev %s ingress", nic.Name),
+		}
+		for _, shellCmd := range shellCommands {
+			// 没有ingress qdisc时查询ingress filter会报错，忽略即可。
+			if result, err := util.ExecCommandBlock(shellCmd); err == nil {
+				lines = append(lines, prefixLines(nic.Name, result)...)
+			}
+		}
+	}
+	return lines
+}
+
+func captureIptables() []string {
+	var lines []string
+	result, err := util.ExecCommandBlock("iptables-save")
+	if err != nil {
+		return lines
+	}
+	for _, line := range strings.Split(result, "\n") {
+		// 注释行含有生成时间，计数器随流量变化，都不属于环境差异。
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, iptablesCounterRegexp.ReplaceAllString(line, "[0:0]"))
+	}
+	return lines
+}
+
+func captureLink() []string {
+	var lines []string
+	nics, err := net.Interfaces()
+	if err != nil {
+		return lines
+	}
+	for _, nic := range nics {
+		linkState := "down"
+		if nic.Flags&net.FlagUp != 0 {
+			linkState = "up"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", nic.Name, linkState))
+	}
+	return lines
+}
+
+func captureDisk() []string {
+	var lines []string
+	statePaths, err := filepath.Glob("/sys/block/*/device/state")
+	if err != nil {
+		return lines
+	}
+	for _, statePath := range statePaths {
+		content, err := ioutil.ReadFile(statePath)
+		if err != nil {
+			continue
+		}
+		disk := filepath.Base(filepath.Dir(filepath.Dir(statePath)))
+		lines = append(lines, fmt.Sprintf("%s %s", disk, strings.TrimSpace(string(content))))
+	}
+	return lines
+}
+
+func capturePcie() []string {
+	var lines []string
+	devicePaths, err := filepath.Glob("/sys/bus/pci/devices/*")
+	if err != nil {
+		return lines
+	}
+	for _, devicePath := range devicePaths {
+		driver := "none"
+		if driverPath, err := filepath.EvalSymlinks(filepath.Join(devicePath, "driver")); err == nil {
+			driver = filepath.Base(driverPath)
+		}
+		lines = append(lines, fmt.Sprintf("%s driver=%s", filepath.Base(devicePath), driver))
+	}
+	return lines
+}
+
+func subjectOf(section string, line string) string {
+	if section == sectionIptables {
+		if match := iptablesInterfaceRegexp.FindStringSubmatch(line); match != nil {
+			return match[1]
+		}
+		return ""
+	}
+	return strings.SplitN(line, " ", 2)[0]
+}
+
+// countLines 统计条目出现次数，tc filter等输出中存在重复的行。
+func countLines(lines []string) map[string]int {
+	counts := make(map[string]int)
+	for _, line := range lines {
+		counts[line]++
+	}
+	return counts
+}
+
+func diffLines(section string, change string, from map[string]int, to map[string]int) []Leak {
+	var leaks []Leak
+	for line, count := range from {
+		for i := to[line]; i < count; i++ {
+			leaks = append(leaks, Leak{Section: section, Subject: subjectOf(section, line), Change: change, Line: line})
+		}
+	}
+	return leaks
+}
+
+// Diff 对比注入前与清理后的快照，只对比两次都采集到的快照项。
+func Diff(before Snapshot, after Snapshot) []Leak {
+	var leaks []Leak
+	for section, beforeLines := range before {
+		afterLines, ok := after[section]
+		if !ok {
+			continue
+		}
+		beforeCounts := countLines(beforeLines)
+		afterCounts := countLines(afterLines)
+		leaks = append(leaks, diffLines(section, "added", afterCounts, beforeCounts)...)
+		leaks = append(leaks, diffLines(section, "missing", beforeCounts, afterCounts)...)
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if leaks[i].Section != leaks[j].Section {
+			return leaks[i].Section < leaks[j].Section
+		}
+		return leaks[i].Line < leaks[j].Line
+	})
+	return leaks
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before Snapshot
+		after  Snapshot
+		want   []Leak
+	}{
+		{
+			name: "restored",
+			before: Snapshot{
+				sectionLink: {"eth1 up", "eth2 up"},
+				sectionTc:   {"eth1 qdisc fq_codel 0: root refcnt 2"},
+			},
+			after: Snapshot{
+				sectionLink: {"eth2 up", "eth1 up"},
+				sectionTc:   {"eth1 qdisc fq_codel 0: root refcnt 2"},
+			},
+			want: nil,
+		},
+		{
+			name: "added and missing",
+			before: Snapshot{
+				sectionLink: {"eth1 up"},
+				sectionTc:   {"eth1 qdisc fq_codel 0: root refcnt 2"},
+			},
+			after: Snapshot{
+				sectionLink: {"eth1 down"},
+				sectionTc:   {"eth1 qdisc netem a0: root refcnt 2 limit 1000 delay 100ms"},
+			},
+			want: []Leak{
+				{Section: sectionLink, Subject: "eth1", Change: "added", Line: "eth1 down"},
+				{Section: sectionLink, Subject: "eth1", Change: "missing", Line: "eth1 up"},
+				{Section: sectionTc, Subject: "eth1", Change: "missing", Line: "eth1 qdisc fq_codel 0: root refcnt 2"},
+				{Section: sectionTc, Subject: "eth1", Change: "added", Line: "eth1 qdisc netem a0: root refcnt 2 limit 1000 delay 100ms"},
+			},
+		},
+		{
+			name:   "duplicated lines",
+			before: Snapshot{sectionTc: {"eth1 filter parent a0: protocol ip pref 1 u32 chain 0"}},
+			after: Snapshot{sectionTc: {
+				"eth1 filter parent a0: protocol ip pref 1 u32 chain 0",
+				"eth1 filter parent a0: protocol ip pref 1 u32 chain 0",
+			}},
+			want: []Leak{
+				{Section: sectionTc, Subject: "eth1", Change: "added", Line: "eth1 filter parent a0: protocol ip pref 1 u32 chain 0"},
+			},
+		},
+		{
+			name:   "iptables interface",
+			before: Snapshot{sectionIptables: {"*filter", "COMMIT"}},
+			after:  Snapshot{sectionIptables: {"*filter", "-A INPUT -i eth1 -j DROP", "-A OUTPUT -j DROP", "COMMIT"}},
+			want: []Leak{
+				{Section: sectionIptables, Subject: "eth1", Change: "added", Line: "-A INPUT -i eth1 -j DROP"},
+				{Section: sectionIptables, Subject: "", Change: "added", Line: "-A OUTPUT -j DROP"},
+			},
+		},
+		{
+			name:   "section missing after remove",
+			before: Snapshot{sectionIptables: {"*filter"}, sectionDisk: {"sdb running"}},
+			after:  Snapshot{sectionDisk: {"sdb offline"}},
+			want: []Leak{
+				{Section: sectionDisk, Subject: "sdb", Change: "added", Line: "sdb offline"},
+				{Section: sectionDisk, Subject: "sdb", Change: "missing", Line: "sdb running"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
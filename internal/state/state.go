@@ -0,0 +1,273 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"arsenal-hardware/internal/probe"
+	"arsenal-hardware/internal/snapshot"
+	"arsenal-hardware/util"
+)
+
+const (
+	// Injected 故障处于注入状态。
+	Injected = "injected"
+	// Removed 故障已清理。
+	Removed = "removed"
+)
+
+// Record 一次故障注入的状态记录，持久化在arsenal/logs/state/$id.json文件。
+type Record struct {
+	ID         string            `json:"id"`
+	FaultType  string            `json:"fault-type"`
+	Flags      map[string]string `json:"flags"`
+	Status     string            `json:"status"`
+	InjectTime time.Time         `json:"inject-time"`
+	RemoveTime time.Time         `json:"remove-time"`
+	// Options 注入时指定的框架参数，如：--verify true。
+	Options map[string]string `json:"options,omitempty"`
+	// Workers 故障相关的后台进程，如：周期性闪断、参数渐变、稳态检查。
+	Workers []*Worker `json:"workers,omitempty"`
+	// Verification 故障注入后的生效校验结果，未开启校验时为空。
+	Verification *Verification `json:"verification,omitempty"`
+	// BeforeSnapshot 与AfterSnapshot分别为注入前与清理后的主机环境快照。
+	BeforeSnapshot snapshot.Snapshot `json:"before-snapshot,omitempty"`
+	AfterSnapshot  snapshot.Snapshot `json:"after-snapshot,omitempty"`
+	// RestoreLeaks 清理后未恢复到注入前状态的条目。
+	RestoreLeaks []snapshot.Leak `json:"restore-leaks,omitempty"`
+	// ProbeResults 注入前与清理后的稳态检查结果，以及故障期间导致中止的检查结果。
+	ProbeResults []probe.Result `json:"probe-results,omitempty"`
+	// AbortReason 故障期间稳态检查失败导致故障被自动清理的原因。
+	AbortReason string `json:"abort-reason,omitempty"`
+}
+
+// Worker 获取指定类型的后台进程，不存在时返回nil。
+func (r *Record) Worker(mode string) *Worker {
+	for _, worker := range r.Workers {
+		if worker.Mode == mode {
+			return worker
+		}
+	}
+	return nil
+}
+
+// Target 获取故障目标的类型与名称，如：interface、eth0，没有目标参数时返回空字符串。
+func (r *Record) Target() (string, string) {
+	for _, kind := range []string{"interface", "device", "bdf"} {
+		if name, ok := r.Flags[kind]; ok {
+			return kind, name
+		}
+	}
+	return "", ""
+}
+
+// Worker 持续运行故障的后台进程信息。
+type Worker struct {
+	Mode    string            `json:"mode"`
+	Pid     int               `json:"pid"`
+	Options map[string]string `json:"options"`
+	LogPath string            `json:"log-path"`
+	// Error 后台进程异常退出的原因。
+	Error string `json:"error,omitempty"`
+}
+
+// Verification 故障生效校验结果。
+type Verification struct {
+	Passed bool      `json:"passed"`
+	Detail string    `json:"detail"`
+	Time   time.Time `json:"time"`
+}
+
+// New 创建故障注入状态记录。
+func New(faultType string, flags map[string]string) *Record {
+	now := time.Now()
+	return &Record{
+		ID:         fmt.Sprintf("%s-%d", faultType, now.UnixNano()),
+		FaultType:  faultType,
+		Flags:      flags,
+		Status:     Injected,
+		InjectTime: now,
+	}
+}
+
+// Key 故障模式与有序参数组成的键值，注入与清理使用相同的参数，据此找到对应的注入记录。
+func (r *Record) Key() string {
+	return Key(r.FaultType, r.Flags)
+}
+
+// Key 生成故障模式与参数对应的键值，如：network-delay --delay 10ms --interface eth0。
+func Key(faultType string, flags map[string]string) string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	key := faultType
+	for _, name := range names {
+		key = fmt.Sprintf("%s --%s %s", key, name, flags[name])
+	}
+	return key
+}
+
+func getStateDir() (string, error) {
+	arsenalLogDir, err := util.GetArsenalLogsDir()
+	if err != nil {
+		return "", fmt.Errorf("get arsenal logs dir failed(%v)", err)
+	}
+	stateDir := filepath.Join(arsenalLogDir, "state")
+	if err := os.MkdirAll(stateDir, 0750); err != nil {
+		return "", fmt.Errorf("create state dir(%s) failed(%v)", stateDir, err)
+	}
+	return stateDir, nil
+}
+
+// Save 持久化故障注入状态记录，先写临时文件再重命名，避免读到不完整的记录。
+func Save(r *Record) error {
+	stateDir, err := getStateDir()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal record(%s) failed(%v)", r.ID, err)
+	}
+	recordPath := filepath.Join(stateDir, fmt.Sprintf("%s.json", r.ID))
+	tmpPath := fmt.Sprintf("%s.tmp", recordPath)
+	if err := ioutil.WriteFile(tmpPath, content, 0640); err != nil {
+		return fmt.Errorf("write record(%s) failed(%v)", tmpPath, err)
+	}
+	return os.Rename(tmpPath, recordPath)
+}
+
+// LogPath 获取故障后台进程的日志文件路径。
+func LogPath(id string, mode string) (string, error) {
+	arsenalLogDir, err := util.GetArsenalLogsDir()
+	if err != nil {
+		return "", fmt.Errorf("get arsenal logs dir failed(%v)", err)
+	}
+	return filepath.Join(arsenalLogDir, fmt.Sprintf("%s-%s.log", id, mode)), nil
+}
+
+// Load 读取指定id的故障注入状态记录。
+func Load(id string) (*Record, error) {
+	stateDir, err := getStateDir()
+	if err != nil {
+		return nil, err
+	}
+	return loadFile(filepath.Join(stateDir, fmt.Sprintf("%s.json", id)))
+}
+
+func loadFile(path string) (*Record, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Record
+	if err := json.Unmarshal(content, &r); err != nil {
+		return nil, fmt.Errorf("unmarshal record(%s) failed(%v)", path, err)
+	}
+	return &r, nil
+}
+
+// List 按注入时间顺序返回所有故障注入状态记录。
+func List() ([]*Record, error) {
+	stateDir, err := getStateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(stateDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*Record, 0, len(paths))
+	for _, path := range paths {
+		r, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].InjectTime.Before(records[j].InjectTime)
+	})
+	return records, nil
+}
+
+// Active 返回所有处于注入状态的故障记录。
+func Active() ([]*Record, error) {
+	records, err := List()
+	if err != nil {
+		return nil, err
+	}
+	active := make([]*Record, 0, len(records))
+	for _, r := range records {
+		if r.Status == Injected {
+			active = append(active, r)
+		}
+	}
+	return active, nil
+}
+
+// FindActive 查找故障模式与参数对应的处于注入状态的故障记录，未找到时返回nil。
+// 优先按全部参数匹配；其次忽略ignored中的框架参数后匹配，如：旧版本记录在参数中的--ramp-to，
+// 只有一条记录匹配时使用该记录。故障参数不同的记录不会被匹配。
+func FindActive(faultType string, flags map[string]string, ignored map[string]bool) (*Record, error) {
+	active, err := Active()
+	if err != nil {
+		return nil, err
+	}
+	return findActive(active, faultType, flags, ignored), nil
+}
+
+func findActive(active []*Record, faultType string, flags map[string]string, ignored map[string]bool) *Record {
+	key := Key(faultType, flags)
+	strippedKey := Key(faultType, withoutFlags(flags, ignored))
+	var candidates []*Record
+	for _, r := range active {
+		if r.Key() == key {
+			return r
+		}
+		if Key(r.FaultType, withoutFlags(r.Flags, ignored)) == strippedKey {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	return nil
+}
+
+// withoutFlags 复制参数并去掉ignored中的参数。
+func withoutFlags(flags map[string]string, ignored map[string]bool) map[string]string {
+	result := make(map[string]string, len(flags))
+	for name, value := range flags {
+		if !ignored[name] {
+			result[name] = value
+		}
+	}
+	return result
+}
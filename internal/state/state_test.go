@@ -0,0 +1,65 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"testing"
+)
+
+func TestFindActive(t *testing.T) {
+	ignored := map[string]bool{"ramp-to": true, "ramp-duration": true, "verify": true}
+	delay := &Record{ID: "delay", FaultType: "network-delay", Flags: map[string]string{"interface": "eth1", "delay": "100ms"}}
+	legacyRamp := &Record{ID: "ramp", FaultType: "network-loss",
+		Flags: map[string]string{"interface": "eth1", "percent": "10%", "ramp-to": "50%", "ramp-duration": "1m"}}
+	lossEth2 := &Record{ID: "loss-eth2", FaultType: "network-loss", Flags: map[string]string{"interface": "eth2", "percent": "10%"}}
+	active := []*Record{delay, legacyRamp, lossEth2}
+	tests := []struct {
+		name      string
+		faultType string
+		flags     map[string]string
+		want      *Record
+	}{
+		{name: "exact", faultType: "network-delay", flags: map[string]string{"interface": "eth1", "delay": "100ms"}, want: delay},
+		{name: "framework flags differ", faultType: "network-loss",
+			flags: map[string]string{"interface": "eth1", "percent": "10%"}, want: legacyRamp},
+		{name: "fault params differ", faultType: "network-delay",
+			flags: map[string]string{"interface": "eth1", "delay": "200ms"}, want: nil},
+		{name: "fault param missing", faultType: "network-delay", flags: map[string]string{"interface": "eth1"}, want: nil},
+		{name: "other target", faultType: "network-delay",
+			flags: map[string]string{"interface": "eth2", "delay": "100ms"}, want: nil},
+		{name: "other fault type", faultType: "network-corrupt",
+			flags: map[string]string{"interface": "eth2", "percent": "10%"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findActive(active, tt.faultType, tt.flags, ignored); got != tt.want {
+				t.Errorf("findActive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindActiveAmbiguous(t *testing.T) {
+	ignored := map[string]bool{"ramp-to": true}
+	active := []*Record{
+		{ID: "first", FaultType: "network-loss", Flags: map[string]string{"interface": "eth1", "percent": "10%", "ramp-to": "20%"}},
+		{ID: "second", FaultType: "network-loss", Flags: map[string]string{"interface": "eth1", "percent": "10%", "ramp-to": "30%"}},
+	}
+	if got := findActive(active, "network-loss", map[string]string{"interface": "eth1", "percent": "10%"}, ignored); got != nil {
+		t.Errorf("findActive() = %s, want nil for ambiguous records", got.ID)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topology
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"arsenal-hardware/internal/parse"
+	"arsenal-hardware/submodules"
+	"arsenal-hardware/util"
+)
+
+func init() {
+	submodules.AddCommand("topology", topology)
+}
+
+const (
+	// KindRootBus pcie root bus，如：0000:00。
+	KindRootBus = "root-bus"
+	// KindBridge pcie桥，下游挂有其他pcie设备。
+	KindBridge = "bridge"
+	// KindFunction pcie设备功能。
+	KindFunction = "function"
+	// KindNet 网卡。
+	KindNet = "net"
+	// KindBlock 块设备。
+	KindBlock = "block"
+	// KindNvmeNamespace nvme命名空间。
+	KindNvmeNamespace = "nvme-namespace"
+
+	sysDevicesPath = "/sys/devices"
+	// pciBridgeClass pcie桥的class code前缀。
+	pciBridgeClass = "0x0604"
+)
+
+var (
+	rootBusRegexp       = regexp.MustCompile(`^pci([0-9a-f]{4}:[0-9a-f]{2})$`)
+	nvmeNamespaceRegexp = regexp.MustCompile(`^nvme\d+n\d+$`)
+)
+
+// Node 硬件拓扑树中的节点。
+type Node struct {
+	Kind     string  `json:"kind"`
+	Name     string  `json:"name"`
+	Driver   string  `json:"driver,omitempty"`
+	Class    string  `json:"class,omitempty"`
+	Vendor   string  `json:"vendor,omitempty"`
+	Device   string  `json:"device,omitempty"`
+	Path     string  `json:"path"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+// Find 在以当前节点为根的子树中查找指定名称的节点，未找到时返回nil。
+func (n *Node) Find(name string) *Node {
+	if n.Name == name {
+		return n
+	}
+	for _, child := range n.Children {
+		if node := child.Find(name); node != nil {
+			return node
+		}
+	}
+	return nil
+}
+
+func readAttr(devicePath string, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(devicePath, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// newFunction 读取pcie设备的驱动与id信息，并递归构建其下游设备。
+func newFunction(devicePath string, index map[string]*Node) *Node {
+	node := &Node{
+		Kind:   KindFunction,
+		Name:   filepath.Base(devicePath),
+		Class:  readAttr(devicePath, "class"),
+		Vendor: readAttr(devicePath, "vendor"),
+		Device: readAttr(devicePath, "device"),
+		Path:   devicePath,
+	}
+	if driverPath, err := filepath.EvalSymlinks(filepath.Join(devicePath, "driver")); err == nil {
+		node.Driver = filepath.Base(driverPath)
+	}
+	node.Children = pcieChildren(devicePath, index)
+	if len(node.Children) != 0 || strings.HasPrefix(node.Class, pciBridgeClass) {
+		node.Kind = KindBridge
+	}
+	index[node.Name] = node
+	return node
+}
+
+// pcieChildren 获取sysfs目录下直接挂载的pcie设备。
+func pcieChildren(devicePath string, index map[string]*Node) []*Node {
+	entries, err := ioutil.ReadDir(devicePath)
+	if err != nil {
+		return nil
+	}
+	var children []*Node
+	for _, entry := range entries {
+		if entry.IsDir() && util.IsBdf(entry.Name()) {
+			children = append(children, newFunction(filepath.Join(devicePath, entry.Name()), index))
+		}
+	}
+	return children
+}
+
+// attachClassDevices 将/sys/class下的设备挂到其最近的上游pcie设备上，虚拟设备与分区会被忽略。
+func attachClassDevices(classPath string, kind string, index map[string]*Node) {
+	entries, err := ioutil.ReadDir(classPath)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(classPath, entry.Name())
+		if util.FileIsExist(filepath.Join(entryPath, "partition")) {
+			continue
+		}
+		devicePath, err := filepath.EvalSymlinks(entryPath)
+		if err != nil {
+			continue
+		}
+		ancestors := util.PcieAncestors(devicePath)
+		if len(ancestors) == 0 {
+			continue
+		}
+		parent, ok := index[ancestors[len(ancestors)-1]]
+		if !ok {
+			continue
+		}
+		node := &Node{Kind: kind, Name: entry.Name(), Path: devicePath}
+		if kind == KindBlock && nvmeNamespaceRegexp.MatchString(entry.Name()) {
+			node.Kind = KindNvmeNamespace
+		}
+		parent.Children = append(parent.Children, node)
+	}
+}
+
+// Discover 遍历sysfs构建硬件拓扑：pcie root bus、桥与设备功能，以及其上的网卡、块设备与nvme命名空间。
+func Discover() ([]*Node, error) {
+	entries, err := ioutil.ReadDir(sysDevicesPath)
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed(%v)", sysDevicesPath, err)
+	}
+
+	index := make(map[string]*Node)
+	var roots []*Node
+	for _, entry := range entries {
+		match := rootBusRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		rootPath := filepath.Join(sysDevicesPath, entry.Name())
+		roots = append(roots, &Node{
+			Kind:     KindRootBus,
+			Name:     match[1],
+			Path:     rootPath,
+			Children: pcieChildren(rootPath, index),
+		})
+	}
+	attachClassDevices("/sys/class/net", KindNet, index)
+	attachClassDevices("/sys/class/block", KindBlock, index)
+	return roots, nil
+}
+
+// RootBus 根据硬件拓扑获取pcie设备所在的root bus。
+func RootBus(bdf string) (string, error) {
+	roots, err := Discover()
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		if root.Find(bdf) != nil {
+			return root.Name, nil
+		}
+	}
+	return "", fmt.Errorf("pcie device(%s) not found in topology", bdf)
+}
+
+func (n *Node) print(depth int) {
+	line := fmt.Sprintf("%s%s %s", strings.Repeat("  ", depth), n.Kind, n.Name)
+	if n.Driver != "" {
+		line = fmt.Sprintf("%s [%s]", line, n.Driver)
+	}
+	if n.Class != "" {
+		line = fmt.Sprintf("%s class %s %s:%s", line, n.Class, n.Vendor, n.Device)
+	}
+	fmt.Println(line)
+	for _, child := range n.Children {
+		child.print(depth + 1)
+	}
+}
+
+// topology 输出硬件拓扑：arsenal-hardware topology --format tree|json。
+func topology(inputArgs []string) error {
+	roots, err := Discover()
+	if err != nil {
+		return err
+	}
+	format := parse.TransInputFlagsToMap(inputArgs)["format"]
+	switch format {
+	case "", "tree":
+		for _, root := range roots {
+			root.print(0)
+		}
+		return nil
+	case "json":
+		content, err := json.MarshalIndent(roots, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal topology failed(%v)", err)
+		}
+		fmt.Println(string(content))
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
@@ -26,6 +26,13 @@ import (
 
 // Run 运行故障注入原子能力。
 func Run(args []string) error {
+	// 独立命令不需要指定模块与故障模式。
+	if len(args) > submodules.OpsTypeIndex {
+		if command, ok := submodules.Commands[args[submodules.OpsTypeIndex]]; ok {
+			return command(args)
+		}
+	}
+
 	var minimumInputArgs = 4
 	// 在cobra中已经做了参数校验，只做简单参数个数校验。
 	if len(args) < minimumInputArgs {
@@ -19,6 +19,14 @@ package all
 import (
 	// 添加注入清理接口
 	_ "arsenal-hardware/internal/operations"
+	// 添加doctor命令
+	_ "arsenal-hardware/internal/doctor"
+	// 添加chaos命令
+	_ "arsenal-hardware/internal/chaos"
+	// 添加report命令
+	_ "arsenal-hardware/internal/report"
+	// 添加topology命令
+	_ "arsenal-hardware/internal/topology"
 	// 向全局故障相关操作接口map中添加disk类型接口
 	_ "arsenal-hardware/submodules/disk"
 	// 向全局故障相关操作接口map中添加pcie类型接口
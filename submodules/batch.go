@@ -0,0 +1,200 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"arsenal-hardware/internal/parse"
+	"arsenal-hardware/internal/state"
+)
+
+// defaultBatchWorkers 批量注入时默认的并发数。
+const defaultBatchWorkers = 4
+
+// batchTargetFlags 支持以逗号分隔指定多个目标的参数，如：--interface eth1,eth2。
+var batchTargetFlags = []string{"interface", "device", "bdf"}
+
+type batchResult struct {
+	target string
+	args   []string
+	output string
+	err    error
+}
+
+// batchTargets 查找以逗号分隔指定了多个目标的参数，返回参数值在输入参数中的索引与目标列表。
+func batchTargets(inputArgs []string) (int, []string) {
+	for index := FaultTypeIndex + 1; index+1 < len(inputArgs); index++ {
+		for _, name := range batchTargetFlags {
+			if inputArgs[index] == fmt.Sprintf("--%s", name) && strings.Contains(inputArgs[index+1], ",") {
+				var targets []string
+				for _, target := range strings.Split(inputArgs[index+1], ",") {
+					if target = strings.TrimSpace(target); target != "" {
+						targets = append(targets, target)
+					}
+				}
+				return index + 1, targets
+			}
+		}
+	}
+	return -1, nil
+}
+
+// targetArgs 生成单个目标的输入参数，去掉只对批量操作有效的参数。
+func targetArgs(inputArgs []string, valueIndex int, target string) []string {
+	args := make([]string, 0, len(inputArgs))
+	for index := 0; index < len(inputArgs); index++ {
+		if inputArgs[index] == "--batch-workers" && index+1 < len(inputArgs) {
+			index++
+			continue
+		}
+		if index == valueIndex {
+			args = append(args, target)
+			continue
+		}
+		args = append(args, inputArgs[index])
+	}
+	return args
+}
+
+// runTarget 以子进程方式对单个目标执行故障操作，各目标互不影响故障模式的内部状态。
+func runTarget(args []string) (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	output, err := exec.Command(exePath, args[1:]...).CombinedOutput()
+	return strings.TrimSpace(string(output)), err
+}
+
+// runTargets 通过有界协程池对多个目标执行故障操作，结果按目标顺序返回。
+func runTargets(argsList [][]string, targets []string, workers int) []*batchResult {
+	results := make([]*batchResult, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				output, err := runTarget(argsList[index])
+				results[index] = &batchResult{target: targets[index], args: argsList[index], output: output, err: err}
+			}
+		}()
+	}
+	for index := range targets {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// printBatchResults 输出每个目标的执行结果，返回失败的目标。
+func printBatchResults(opsType string, results []*batchResult) []string {
+	var failed []string
+	for _, result := range results {
+		status := "succeeded"
+		if result.err != nil {
+			status = "failed"
+			failed = append(failed, result.target)
+		}
+		fmt.Printf("%s %s %s\n", result.target, opsType, status)
+		if result.output != "" {
+			fmt.Printf("  %s\n", strings.ReplaceAll(result.output, "\n", "\n  "))
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// isApplied 判断目标的故障是否已经生效：子进程执行成功，或者子进程失败但保存了本次注入的状态记录，
+// 如：注入后校验未通过、后台进程启动失败。
+func isApplied(result *batchResult, record *state.Record, start time.Time) bool {
+	if result.err == nil {
+		return true
+	}
+	return record != nil && record.Status == state.Injected && !record.InjectTime.Before(start)
+}
+
+// runBatch 对多个目标批量执行故障注入或清理，注入时任意目标失败则回滚所有故障已经生效的目标。
+func runBatch(inputArgs []string, faultType string, valueIndex int, targets []string,
+	options map[string]string) error {
+	workers := defaultBatchWorkers
+	if value, ok := options["batch-workers"]; ok {
+		var err error
+		if workers, err = strconv.Atoi(value); err != nil || workers <= 0 {
+			return fmt.Errorf("invalid batch-workers: %s", value)
+		}
+	}
+
+	opsType := inputArgs[OpsTypeIndex]
+	argsList := make([][]string, 0, len(targets))
+	for _, target := range targets {
+		args := targetArgs(inputArgs, valueIndex, target)
+		argsList = append(argsList, args)
+		// 批量注入前按目标总数检查安全策略，避免注入部分目标后才超出故障数量上限。
+		if opsType == Inject {
+			faultArgs, _ := splitFrameworkFlags(args)
+			if err := checkPolicy(parse.TransInputFlagsToMap(faultArgs), options, len(targets)); err != nil {
+				return fmt.Errorf("%s %s: %v", faultType, target, err)
+			}
+		}
+	}
+
+	start := time.Now()
+	results := runTargets(argsList, targets, workers)
+	failed := printBatchResults(opsType, results)
+	if len(failed) == 0 {
+		return nil
+	}
+	if opsType != Inject {
+		return fmt.Errorf("%s %s failed on: %s", faultType, opsType, strings.Join(failed, ", "))
+	}
+
+	// 回滚故障已经生效的目标，包括注入后校验未通过等执行失败但故障已生效的目标。
+	var rollbackArgs [][]string
+	var rollbackTargets []string
+	for _, result := range results {
+		faultArgs, _ := splitFrameworkFlags(result.args)
+		record, err := findRecord(faultType, faultArgs)
+		if err != nil {
+			fmt.Printf("warning: find %s %s state failed(%v)\n", faultType, result.target, err)
+		}
+		if isApplied(result, record, start) {
+			rollbackArgs = append(rollbackArgs, withOpsType(result.args, Remove))
+			rollbackTargets = append(rollbackTargets, result.target)
+		}
+	}
+	if len(rollbackTargets) == 0 {
+		return fmt.Errorf("%s inject failed on: %s", faultType, strings.Join(failed, ", "))
+	}
+	rollbackFailed := printBatchResults("rollback", runTargets(rollbackArgs, rollbackTargets, workers))
+	if len(rollbackFailed) != 0 {
+		return fmt.Errorf("%s inject failed on: %s, rollback failed on: %s",
+			faultType, strings.Join(failed, ", "), strings.Join(rollbackFailed, ", "))
+	}
+	return fmt.Errorf("%s inject failed on: %s, rolled back: %s",
+		faultType, strings.Join(failed, ", "), strings.Join(rollbackTargets, ", "))
+}
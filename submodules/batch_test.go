@@ -0,0 +1,161 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"arsenal-hardware/internal/state"
+)
+
+func TestBatchTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputArgs []string
+		wantIndex int
+		want      []string
+	}{
+		{
+			name:      "multiple interfaces",
+			inputArgs: []string{"ah", "inject", "network", "down", "--interface", "eth1,eth2"},
+			wantIndex: 5,
+			want:      []string{"eth1", "eth2"},
+		},
+		{
+			name:      "trim spaces and empty targets",
+			inputArgs: []string{"ah", "inject", "disk", "offline", "--device", " sdb, ,sdc ,"},
+			wantIndex: 5,
+			want:      []string{"sdb", "sdc"},
+		},
+		{
+			name:      "trailing comma leaves one target",
+			inputArgs: []string{"ah", "inject", "network", "down", "--interface", "eth0,"},
+			wantIndex: 5,
+			want:      []string{"eth0"},
+		},
+		{
+			name:      "single target",
+			inputArgs: []string{"ah", "inject", "network", "down", "--interface", "eth0"},
+			wantIndex: -1,
+		},
+		{
+			name:      "comma in other flag",
+			inputArgs: []string{"ah", "inject", "network", "down", "--probes", "a,b", "--interface", "eth0"},
+			wantIndex: -1,
+		},
+		{
+			name:      "flag without value",
+			inputArgs: []string{"ah", "inject", "network", "down", "--interface"},
+			wantIndex: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, targets := batchTargets(tt.inputArgs)
+			if index != tt.wantIndex || !reflect.DeepEqual(targets, tt.want) {
+				t.Errorf("batchTargets() = %d, %v, want %d, %v", index, targets, tt.wantIndex, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		inputArgs  []string
+		valueIndex int
+		target     string
+		want       []string
+	}{
+		{
+			name:       "replace value",
+			inputArgs:  []string{"ah", "inject", "network", "down", "--interface", "eth1,eth2"},
+			valueIndex: 5,
+			target:     "eth2",
+			want:       []string{"ah", "inject", "network", "down", "--interface", "eth2"},
+		},
+		{
+			name: "strip batch workers",
+			inputArgs: []string{"ah", "inject", "network", "down", "--batch-workers", "2",
+				"--interface", "eth1,eth2", "--timeout", "10"},
+			valueIndex: 7,
+			target:     "eth1",
+			want:       []string{"ah", "inject", "network", "down", "--interface", "eth1", "--timeout", "10"},
+		},
+		{
+			name:       "strip batch workers after value",
+			inputArgs:  []string{"ah", "remove", "disk", "offline", "--device", "sdb,sdc", "--batch-workers", "4"},
+			valueIndex: 5,
+			target:     "sdc",
+			want:       []string{"ah", "remove", "disk", "offline", "--device", "sdc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetArgs(tt.inputArgs, tt.valueIndex, tt.target); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targetArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsApplied(t *testing.T) {
+	start := time.Now()
+	failed := &batchResult{target: "eth1", err: errors.New("exit status 1")}
+	tests := []struct {
+		name   string
+		result *batchResult
+		record *state.Record
+		want   bool
+	}{
+		{
+			name:   "child succeeded",
+			result: &batchResult{target: "eth1"},
+			want:   true,
+		},
+		{
+			name:   "child failed without record",
+			result: failed,
+		},
+		{
+			name:   "child failed after inject",
+			result: failed,
+			record: &state.Record{Status: state.Injected, InjectTime: start.Add(time.Second)},
+			want:   true,
+		},
+		{
+			name:   "record injected before batch",
+			result: failed,
+			record: &state.Record{Status: state.Injected, InjectTime: start.Add(-time.Second)},
+		},
+		{
+			name:   "record removed",
+			result: failed,
+			record: &state.Record{Status: state.Removed, InjectTime: start.Add(time.Second)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isApplied(tt.result, tt.record, start); got != tt.want {
+				t.Errorf("isApplied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -34,7 +34,7 @@ type blocked struct {
 }
 
 func (b *blocked) Prepare(inputArgs []string) error {
-	return b.disk.diskStateControlPreRun(parse.TransInputFlagsToMap(inputArgs))
+	return b.disk.diskStateControlPreRun(b.FaultType, parse.TransInputFlagsToMap(inputArgs))
 }
 
 func (b *blocked) FaultInject(_ []string) error {
@@ -44,3 +44,7 @@ func (b *blocked) FaultInject(_ []string) error {
 func (b *blocked) FaultRemove(_ []string) error {
 	return b.disk.changeDiskState("running")
 }
+
+func (b *blocked) FaultVerify(_ []string) (string, error) {
+	return b.disk.verifyDiskState("blocked")
+}
@@ -34,7 +34,7 @@ type offline struct {
 }
 
 func (o *offline) Prepare(inputArgs []string) error {
-	return o.disk.diskStateControlPreRun(parse.TransInputFlagsToMap(inputArgs))
+	return o.disk.diskStateControlPreRun(o.FaultType, parse.TransInputFlagsToMap(inputArgs))
 }
 
 func (o *offline) FaultInject(_ []string) error {
@@ -44,3 +44,7 @@ func (o *offline) FaultInject(_ []string) error {
 func (o *offline) FaultRemove(_ []string) error {
 	return o.disk.changeDiskState("running")
 }
+
+func (o *offline) FaultVerify(_ []string) (string, error) {
+	return o.disk.verifyDiskState("offline")
+}
@@ -19,6 +19,7 @@ package disk
 import (
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"arsenal-hardware/util"
 )
@@ -36,7 +37,9 @@ func (d *disk) dependentsCmdCheck() error {
 	return nil
 }
 
-func (d *disk) diskStateControlPreRun(flags map[string]string) error {
+func (d *disk) diskStateControlPreRun(faultType string, flags map[string]string) error {
+	// 故障处理对象是单例，清除上一次调用留下的磁盘状态。
+	*d = disk{}
 	if err := d.dependentsCmdCheck(); err != nil {
 		return err
 	}
@@ -54,6 +57,13 @@ func (d *disk) diskStateControlPreRun(flags map[string]string) error {
 		return fmt.Errorf("set disk: %s state control file path failed", d.devName)
 	}
 
+	preflight := util.NewPreflight(faultType)
+	preflight.RequireCapability(util.CapSysAdmin)
+	preflight.RequireWritable(d.stateCtlPath)
+	if err := preflight.Check(); err != nil {
+		return err
+	}
+
 	if err := d.setCurState(); err != nil {
 		return fmt.Errorf("set disk: %s state control file failed(%v)", d.devName, err)
 	}
@@ -96,3 +106,16 @@ func (d *disk) changeDiskState(state string) error {
 	}
 	return nil
 }
+
+// verifyDiskState 回读磁盘状态控制文件，校验磁盘是否处于目标状态。
+func (d *disk) verifyDiskState(state string) (string, error) {
+	content, err := ioutil.ReadFile(d.stateCtlPath)
+	if err != nil {
+		return "", err
+	}
+	curState := strings.TrimSpace(string(content))
+	if curState != state {
+		return "", fmt.Errorf("disk: %s in %s state, expect %s", d.devName, curState, state)
+	}
+	return fmt.Sprintf("%s: %s", d.stateCtlPath, curState), nil
+}
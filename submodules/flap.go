@@ -0,0 +1,199 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"arsenal-hardware/internal/event"
+	"arsenal-hardware/internal/state"
+)
+
+// flapFlags 周期性闪断相关的框架参数。
+var flapFlags = []string{"flap-on", "flap-off", "flap-jitter", "flap-cycles"}
+
+type flapOptions struct {
+	on     time.Duration
+	off    time.Duration
+	jitter time.Duration
+	// cycles 闪断次数，0表示一直运行直到故障被清理。
+	cycles int
+}
+
+// parseFlapOptions 解析闪断参数：--flap-on 10s --flap-off 5s --flap-jitter 1s --flap-cycles 10。
+func parseFlapOptions(options map[string]string) (*flapOptions, error) {
+	var err error
+	flap := &flapOptions{}
+	if flap.on, err = time.ParseDuration(options["flap-on"]); err != nil || flap.on <= 0 {
+		return nil, fmt.Errorf("invalid flap-on: %s", options["flap-on"])
+	}
+	if flap.off, err = time.ParseDuration(options["flap-off"]); err != nil || flap.off <= 0 {
+		return nil, fmt.Errorf("invalid flap-off: %s", options["flap-off"])
+	}
+	if value, ok := options["flap-jitter"]; ok {
+		if flap.jitter, err = time.ParseDuration(value); err != nil || flap.jitter < 0 {
+			return nil, fmt.Errorf("invalid flap-jitter: %s", value)
+		}
+	}
+	if value, ok := options["flap-cycles"]; ok {
+		if flap.cycles, err = strconv.Atoi(value); err != nil || flap.cycles < 0 {
+			return nil, fmt.Errorf("invalid flap-cycles: %s", value)
+		}
+	}
+	return flap, nil
+}
+
+// withJitter 在时长上叠加[-jitter, jitter]范围内的随机抖动。
+func (f *flapOptions) withJitter(duration time.Duration) time.Duration {
+	if f.jitter <= 0 {
+		return duration
+	}
+	duration += time.Duration(rand.Int63n(int64(2*f.jitter)+1)) - f.jitter
+	if duration < 0 {
+		return 0
+	}
+	return duration
+}
+
+// withOpsType 复制输入参数并替换其中的操作类型。
+func withOpsType(inputArgs []string, opsType string) []string {
+	newArgs := make([]string, len(inputArgs))
+	copy(newArgs, inputArgs)
+	newArgs[OpsTypeIndex] = opsType
+	return newArgs
+}
+
+// startFlap 启动后台闪断进程，由后台进程交替执行故障注入与清理。
+func startFlap(record *state.Record, faultArgs []string, options map[string]string) error {
+	if _, err := parseFlapOptions(options); err != nil {
+		return err
+	}
+
+	workerOptions := make(map[string]string)
+	flapArgs := workerArgs(faultArgs, Flap)
+	for _, name := range flapFlags {
+		if value, ok := options[name]; ok {
+			workerOptions[name] = value
+			flapArgs = append(flapArgs, fmt.Sprintf("--%s", name), value)
+		}
+	}
+	return startWorker(record, Flap, flapArgs, workerOptions)
+}
+
+// flapStep 执行一次故障注入或清理，每次执行前都需要按对应的操作类型重新初始化。
+func flapStep(handler FaultOperations, faultArgs []string, opsType string) error {
+	stepArgs := withOpsType(faultArgs, opsType)
+	if err := handler.Prepare(stepArgs); err != nil {
+		return err
+	}
+	return FaultOperationTypes[opsType](handler, stepArgs)
+}
+
+// runFlap 后台闪断进程入口，交替执行故障注入与清理，退出前保证目标已恢复。
+// 收到SIGTERM说明故障正在被清理，由清理流程更新状态记录；闪断次数用完时由本进程更新状态记录。
+func runFlap(handler FaultOperations, faultType string, faultArgs []string, options map[string]string) error {
+	flap, err := parseFlapOptions(options)
+	if err != nil {
+		return err
+	}
+	rand.Seed(time.Now().UnixNano())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	var stopped, restored bool
+	var flapErr error
+	for cycle := 1; flap.cycles == 0 || cycle <= flap.cycles; cycle++ {
+		flapErr = flapStep(handler, faultArgs, Inject)
+		if cycle == 1 {
+			firstInjected(faultType, faultArgs, flapErr)
+		}
+		if flapErr != nil {
+			// 注入失败时尝试清理，避免残留部分生效的故障。
+			restored = flapStep(handler, faultArgs, Remove) == nil
+			break
+		}
+		fmt.Printf("%s cycle %d injected\n", time.Now().Format(time.RFC3339), cycle)
+		stopped = waitOrStop(flap.withJitter(flap.on), stop)
+
+		if flapErr = flapStep(handler, faultArgs, Remove); flapErr != nil {
+			break
+		}
+		restored = true
+		fmt.Printf("%s cycle %d removed\n", time.Now().Format(time.RFC3339), cycle)
+		if stopped || (flap.cycles != 0 && cycle == flap.cycles) {
+			break
+		}
+		if stopped = waitOrStop(flap.withJitter(flap.off), stop); stopped {
+			break
+		}
+		restored = false
+	}
+
+	// 收到退出信号时由清理流程更新状态记录。
+	select {
+	case <-stop:
+		stopped = true
+	default:
+	}
+	if stopped {
+		return flapErr
+	}
+	return finishWorker(faultType, faultArgs, flapErr, restored)
+}
+
+// firstInjected 闪断进程启动时故障尚未注入，由闪断进程在第一次注入后输出事件并记录审计日志。
+func firstInjected(faultType string, faultArgs []string, injectErr error) {
+	var options map[string]string
+	if record, err := findRecord(faultType, faultArgs); err == nil && record != nil {
+		options = record.Options
+	}
+	injectArgs := withOpsType(faultArgs, Inject)
+	if injectErr == nil {
+		markInjected(faultType, injectArgs, options)
+		return
+	}
+	emitEvent(event.Failed, faultType, injectArgs, options, injectErr.Error())
+	writeAudit(faultType, Inject, injectArgs, options, injectErr)
+}
+
+// finishWorker 后台进程自行结束时更新故障状态记录，目标未恢复时记录保持注入状态，等待手动清理。
+func finishWorker(faultType string, faultArgs []string, workerErr error, restored bool) error {
+	record, err := findRecord(faultType, faultArgs)
+	if err != nil || record == nil {
+		return fmt.Errorf("find %s state failed(%v)", faultType, err)
+	}
+	defer emitExpired(Flap, faultType, faultArgs, record.Options, workerErr)
+	if worker := record.Worker(Flap); workerErr != nil && worker != nil {
+		worker.Error = workerErr.Error()
+	}
+	if !restored {
+		if err := state.Save(record); err != nil {
+			return fmt.Errorf("save %s state failed(%v)", faultType, err)
+		}
+		return workerErr
+	}
+	if err := finishRecord(record); err != nil {
+		return err
+	}
+	return workerErr
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFlapOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		want    *flapOptions
+		wantErr string
+	}{
+		{
+			name:    "on and off",
+			options: map[string]string{"flap-on": "10s", "flap-off": "5s"},
+			want:    &flapOptions{on: 10 * time.Second, off: 5 * time.Second},
+		},
+		{
+			name:    "all options",
+			options: map[string]string{"flap-on": "1m", "flap-off": "30s", "flap-jitter": "2s", "flap-cycles": "3"},
+			want:    &flapOptions{on: time.Minute, off: 30 * time.Second, jitter: 2 * time.Second, cycles: 3},
+		},
+		{
+			name:    "zero jitter and unlimited cycles",
+			options: map[string]string{"flap-on": "1s", "flap-off": "1s", "flap-jitter": "0s", "flap-cycles": "0"},
+			want:    &flapOptions{on: time.Second, off: time.Second},
+		},
+		{name: "missing off", options: map[string]string{"flap-on": "10s"}, wantErr: "invalid flap-off: "},
+		{name: "zero on", options: map[string]string{"flap-on": "0s", "flap-off": "5s"}, wantErr: "invalid flap-on: 0s"},
+		{name: "without unit", options: map[string]string{"flap-on": "10", "flap-off": "5s"}, wantErr: "invalid flap-on: 10"},
+		{name: "negative jitter", options: map[string]string{"flap-on": "10s", "flap-off": "5s", "flap-jitter": "-1s"},
+			wantErr: "invalid flap-jitter: -1s"},
+		{name: "invalid cycles", options: map[string]string{"flap-on": "10s", "flap-off": "5s", "flap-cycles": "-1"},
+			wantErr: "invalid flap-cycles: -1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFlapOptions(tt.options)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseFlapOptions() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFlapOptions() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	flap := &flapOptions{jitter: time.Second}
+	for i := 0; i < 100; i++ {
+		if got := flap.withJitter(2 * time.Second); got < time.Second || got > 3*time.Second {
+			t.Fatalf("withJitter(2s) = %v, want within [1s, 3s]", got)
+		}
+		if got := flap.withJitter(500 * time.Millisecond); got < 0 {
+			t.Fatalf("withJitter(500ms) = %v, want not negative", got)
+		}
+	}
+	if got := (&flapOptions{}).withJitter(time.Second); got != time.Second {
+		t.Errorf("withJitter() without jitter = %v, want 1s", got)
+	}
+}
+
+func TestWithOpsType(t *testing.T) {
+	args := []string{"ah", "inject", "network", "down", "--interface", "eth1"}
+	got := withOpsType(args, Flap)
+	if want := []string{"ah", Flap, "network", "down", "--interface", "eth1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("withOpsType() = %q, want %q", got, want)
+	}
+	if args[OpsTypeIndex] != "inject" {
+		t.Errorf("withOpsType() modified input args: %q", args)
+	}
+	if want := []string{Flap, "network", "down", "--interface", "eth1"}; !reflect.DeepEqual(workerArgs(args, Flap), want) {
+		t.Errorf("workerArgs() = %q, want %q", workerArgs(args, Flap), want)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"fmt"
+
+	"arsenal-hardware/internal/event"
+	"arsenal-hardware/internal/hook"
+	"arsenal-hardware/internal/parse"
+)
+
+// runHook 执行故障操作指定阶段的钩子。
+func runHook(stage string, faultType string, faultArgs []string, options map[string]string) error {
+	return hook.Run(&hook.Event{
+		Stage:     stage,
+		Ops:       faultArgs[OpsTypeIndex],
+		Module:    faultArgs[ModuleNameIndex],
+		FaultType: faultType,
+		Flags:     parse.TransInputFlagsToMap(faultArgs),
+		Options:   options,
+	})
+}
+
+// emitEvent 输出故障生命周期事件。
+func emitEvent(eventType string, faultType string, faultArgs []string, options map[string]string, detail string) {
+	event.Emit(eventType, &event.Data{
+		FaultType: faultType,
+		Ops:       faultArgs[OpsTypeIndex],
+		Flags:     parse.TransInputFlagsToMap(faultArgs),
+		Options:   options,
+		Detail:    detail,
+	})
+}
+
+// runFaultOps 执行故障注入或清理，以及前后的钩子。注入后钩子要求中止时回滚已注入的故障。
+func runFaultOps(handler FaultOperations, faultType string, opsType string,
+	faultArgs []string, options map[string]string) error {
+	if err := runHook(hook.PrePrepare, faultType, faultArgs, options); err != nil {
+		return fmt.Errorf("%s %s aborted, %v", faultType, opsType, err)
+	}
+	if err := handler.Prepare(faultArgs); err != nil {
+		return err
+	}
+	emitEvent(event.Prepared, faultType, faultArgs, options, "")
+	ops, ok := FaultOperationTypes[opsType]
+	if !ok {
+		return fmt.Errorf("unsupported operation type: %s", opsType)
+	}
+
+	if opsType == Remove {
+		if err := removeFault(ops, handler, faultType, faultArgs, options); err != nil {
+			return err
+		}
+		emitEvent(event.Removed, faultType, faultArgs, options, "")
+		return runHook(hook.PostRemove, faultType, faultArgs, options)
+	}
+
+	if err := injectFault(ops, handler, faultType, faultArgs, options); err != nil {
+		return err
+	}
+	if err := runHook(hook.PostInject, faultType, faultArgs, options); err != nil {
+		if rollbackErr := rollbackInject(handler, faultType, faultArgs, options); rollbackErr != nil {
+			return fmt.Errorf("%v, rollback %s failed(%v)", err, faultType, rollbackErr)
+		}
+		return fmt.Errorf("%v, %s rolled back", err, faultType)
+	}
+	return nil
+}
+
+// rollbackInject 清理已注入的故障，清理前需要按清理操作重新初始化。
+func rollbackInject(handler FaultOperations, faultType string, faultArgs []string, options map[string]string) error {
+	removeArgs := withOpsType(faultArgs, Remove)
+	if err := handler.Prepare(removeArgs); err != nil {
+		return err
+	}
+	return removeFault(FaultOperationTypes[Remove], handler, faultType, removeArgs, options)
+}
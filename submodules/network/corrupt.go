@@ -43,3 +43,15 @@ func (c *corrupt) FaultInject(_ []string) error {
 func (c *corrupt) FaultRemove(_ []string) error {
 	return c.base.Executor()
 }
+
+func (c *corrupt) FaultVerify(_ []string) (string, error) {
+	return c.base.Verify()
+}
+
+func (c *corrupt) NeedWorker(_ []string) bool {
+	return c.base.NeedRamp()
+}
+
+func (c *corrupt) FaultWork(_ []string, stop <-chan struct{}) error {
+	return c.base.Ramp(stop)
+}
@@ -43,3 +43,15 @@ func (d *delay) FaultInject(_ []string) error {
 func (d *delay) FaultRemove(_ []string) error {
 	return d.base.Executor()
 }
+
+func (d *delay) FaultVerify(_ []string) (string, error) {
+	return d.base.Verify()
+}
+
+func (d *delay) NeedWorker(_ []string) bool {
+	return d.base.NeedRamp()
+}
+
+func (d *delay) FaultWork(_ []string, stop <-chan struct{}) error {
+	return d.base.Ramp(stop)
+}
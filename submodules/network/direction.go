@@ -0,0 +1,216 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"arsenal-hardware/util"
+)
+
+const (
+	// directionEgress 网卡发送的报文。
+	directionEgress = "egress"
+	// directionIngress 网卡接收的报文，通过ingress qdisc重定向到ifb网卡后注入故障。
+	directionIngress = "ingress"
+	// directionBoth 同时在发送与接收方向上注入故障。
+	directionBoth = "both"
+)
+
+// ingressModules 入方向故障依赖的内核模块。
+var ingressModules = []string{"ifb", "sch_ingress", "act_mirred"}
+
+// directions 获取故障注入的方向，默认为出方向。
+func (b *baseInfo) directions() []string {
+	switch b.flags["direction"] {
+	case directionIngress:
+		return []string{directionIngress}
+	case directionBoth:
+		return []string{directionEgress, directionIngress}
+	default:
+		return []string{directionEgress}
+	}
+}
+
+// validateDirection 校验故障注入方向。
+func (b *baseInfo) validateDirection() error {
+	direction, ok := b.flags["direction"]
+	if !ok {
+		return nil
+	}
+	if direction != directionEgress && direction != directionIngress && direction != directionBoth {
+		return fmt.Errorf("invalid direction: %s, support: %s, %s, %s",
+			direction, directionEgress, directionIngress, directionBoth)
+	}
+	return nil
+}
+
+// needIngress 判断是否需要在入方向上注入故障。
+func (b *baseInfo) needIngress() bool {
+	return b.flags["direction"] == directionIngress || b.flags["direction"] == directionBoth
+}
+
+// ifbName 获取网卡入方向报文重定向到的ifb网卡名称，使用网卡的ifindex避免超出网卡名称长度限制。
+func ifbName(nicDevice string) string {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/ifindex", nicDevice))
+	if err != nil {
+		return fmt.Sprintf("ahifb-%s", nicDevice)
+	}
+	return fmt.Sprintf("ahifb%s", strings.TrimSpace(string(content)))
+}
+
+// directionDevice 获取故障qdisc所在的网卡，入方向的故障qdisc在ifb网卡上。
+func directionDevice(nicDevice string, direction string) string {
+	if direction == directionIngress {
+		return ifbName(nicDevice)
+	}
+	return nicDevice
+}
+
+// linkExists 判断网卡是否存在。
+func linkExists(nicDevice string) bool {
+	return util.FileIsExist(fmt.Sprintf("/sys/class/net/%s", nicDevice))
+}
+
+// setupIngress 创建ifb网卡与ingress qdisc，将网卡接收的报文重定向到ifb网卡，已重定向时直接返回ifb网卡。
+func setupIngress(nicDevice string) (string, error) {
+	ifb := ifbName(nicDevice)
+	if linkExists(ifb) {
+		return ifb, nil
+	}
+
+	shellCmd := fmt.Sprintf("tc qdisc show dev %s", nicDevice)
+	result, err := util.ExecCommandBlock(shellCmd)
+	if err != nil {
+		return "", fmt.Errorf("execute: %s failed: %v, result: %s", shellCmd, err, result)
+	}
+	for _, line := range strings.Split(result, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && (fields[1] == "ingress" || fields[1] == "clsact") {
+			return "", fmt.Errorf("%s qdisc already exists on %s, cannot redirect ingress traffic", fields[1], nicDevice)
+		}
+	}
+
+	shellCommands := []string{
+		fmt.Sprintf("ip link add name %s type ifb", ifb),
+		fmt.Sprintf("ip link set dev %s up", ifb),
+		fmt.Sprintf("tc qdisc add dev %s handle ffff: ingress", nicDevice),
+		// tc filter add dev ens18 parent ffff: protocol all u32 match u32 0 0 action mirred egress redirect dev ahifb2
+		fmt.Sprintf("tc filter add dev %s parent ffff: protocol all u32 match u32 0 0 action mirred egress redirect dev %s",
+			nicDevice, ifb),
+	}
+	if err := execTcCommands(shellCommands); err != nil {
+		if teardownErr := teardownIngress(nicDevice); teardownErr != nil {
+			return "", fmt.Errorf("%v, %v", err, teardownErr)
+		}
+		return "", err
+	}
+	return ifb, nil
+}
+
+// teardownIngress ifb网卡上没有故障时删除ingress qdisc与ifb网卡。
+func teardownIngress(nicDevice string) error {
+	ifb := ifbName(nicDevice)
+	state, err := loadTcState(ifb)
+	if err != nil || state != nil {
+		return err
+	}
+
+	var errs []string
+	shellCmd := fmt.Sprintf("tc qdisc del dev %s ingress", nicDevice)
+	if result, err := util.ExecCommandBlock(shellCmd); err != nil && !strings.Contains(result, "Invalid handle") &&
+		!strings.Contains(result, "Cannot find specified qdisc") {
+		errs = append(errs, fmt.Sprintf("execute: %s failed: %s, result: %s", shellCmd, err, result))
+	}
+	if linkExists(ifb) {
+		shellCmd = fmt.Sprintf("ip link del dev %s", ifb)
+		if result, err := util.ExecCommandBlock(shellCmd); err != nil {
+			errs = append(errs, fmt.Sprintf("execute: %s failed: %s, result: %s", shellCmd, err, result))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("teardown ingress redirect of %s failed: %s", nicDevice, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// inject 在各方向上注入当前故障，双向注入时入方向失败会清理已注入的出方向故障。
+func (b *baseInfo) inject(nicDevice string) error {
+	var injected []string
+	for _, direction := range b.directions() {
+		device := nicDevice
+		var err error
+		if direction == directionIngress {
+			if device, err = setupIngress(nicDevice); err != nil {
+				return b.rollbackInject(injected, err)
+			}
+		}
+		err = b.injectDevice(device)
+		if direction == directionIngress {
+			if teardownErr := teardownIngress(nicDevice); teardownErr != nil && err == nil {
+				err = teardownErr
+			}
+		}
+		if err != nil {
+			return b.rollbackInject(injected, err)
+		}
+		injected = append(injected, device)
+	}
+	return nil
+}
+
+// rollbackInject 清理已注入的出方向故障。
+func (b *baseInfo) rollbackInject(injected []string, err error) error {
+	for _, device := range injected {
+		if removeErr := b.removeDevice(device); removeErr != nil {
+			return fmt.Errorf("%v, rollback %s failed: %v", err, device, removeErr)
+		}
+	}
+	return err
+}
+
+// remove 清理各方向上的当前故障，入方向的最后一个故障清理后删除重定向。
+func (b *baseInfo) remove(nicDevice string) error {
+	var errs []string
+	for _, direction := range b.directions() {
+		var err error
+		if direction == directionIngress {
+			err = b.removeIngress(nicDevice)
+		} else {
+			err = b.removeDevice(nicDevice)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (b *baseInfo) removeIngress(nicDevice string) error {
+	ifb := ifbName(nicDevice)
+	if !linkExists(ifb) {
+		return fmt.Errorf("%s not injected on %s ingress", b.fault().name(), nicDevice)
+	}
+	if err := b.removeDevice(ifb); err != nil {
+		return err
+	}
+	return teardownIngress(nicDevice)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDirections(t *testing.T) {
+	tests := []struct {
+		name        string
+		flags       map[string]string
+		wantErr     string
+		want        []string
+		wantIngress bool
+	}{
+		{name: "default egress", flags: map[string]string{}, want: []string{directionEgress}},
+		{name: "egress", flags: map[string]string{"direction": "egress"}, want: []string{directionEgress}},
+		{
+			name:        "ingress",
+			flags:       map[string]string{"direction": "ingress"},
+			want:        []string{directionIngress},
+			wantIngress: true,
+		},
+		{
+			name:        "both",
+			flags:       map[string]string{"direction": "both"},
+			want:        []string{directionEgress, directionIngress},
+			wantIngress: true,
+		},
+		{name: "invalid", flags: map[string]string{"direction": "in"}, wantErr: "invalid direction: in"},
+		{name: "case sensitive", flags: map[string]string{"direction": "Ingress"}, wantErr: "invalid direction: Ingress"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &baseInfo{flags: tt.flags}
+			err := b.validateDirection()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("validateDirection() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateDirection() error = %v", err)
+			}
+			if got := b.directions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("directions() = %v, want %v", got, tt.want)
+			}
+			if got := b.needIngress(); got != tt.wantIngress {
+				t.Errorf("needIngress() = %v, want %v", got, tt.wantIngress)
+			}
+		})
+	}
+}
+
+func TestDirectionDevice(t *testing.T) {
+	if got := directionDevice("eth0", directionEgress); got != "eth0" {
+		t.Errorf("directionDevice(egress) = %s, want eth0", got)
+	}
+	if got := directionDevice("ah-no-such-nic", directionIngress); got != "ahifb-ah-no-such-nic" {
+		t.Errorf("directionDevice(ingress) = %s, want ahifb-ah-no-such-nic", got)
+	}
+}
@@ -68,7 +68,13 @@ func (d *down) setShellCmd() error {
 
 func (d *down) Prepare(inputArgs []string) error {
 	d.flags = parse.TransInputFlagsToMap(inputArgs)
-	return d.setShellCmd()
+	if err := d.setShellCmd(); err != nil {
+		return err
+	}
+
+	preflight := util.NewPreflight(d.FaultType)
+	preflight.RequireCapability(util.CapNetAdmin)
+	return preflight.Check()
 }
 
 func (d *down) FaultInject(_ []string) error {
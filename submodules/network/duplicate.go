@@ -43,3 +43,15 @@ func (d *duplicate) FaultInject(_ []string) error {
 func (d *duplicate) FaultRemove(_ []string) error {
 	return d.base.Executor()
 }
+
+func (d *duplicate) FaultVerify(_ []string) (string, error) {
+	return d.base.Verify()
+}
+
+func (d *duplicate) NeedWorker(_ []string) bool {
+	return d.base.NeedRamp()
+}
+
+func (d *duplicate) FaultWork(_ []string, stop <-chan struct{}) error {
+	return d.base.Ramp(stop)
+}
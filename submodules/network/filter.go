@@ -0,0 +1,180 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// protocolIPv4 只匹配IPv4报文。
+	protocolIPv4 = "ipv4"
+	// protocolIPv6 只匹配IPv6报文。
+	protocolIPv6 = "ipv6"
+	// protocolAll 同时匹配IPv4与IPv6报文。
+	protocolAll = "all"
+)
+
+// filterAddress 获取过滤条件中的地址及前缀，如：10.0.0.0/8、2001:db8::/32，子网掩码可以是前缀长度或点分十进制。
+func filterAddress(flags map[string]string, name string) (string, error) {
+	value := flags[name]
+	mask, hasMask := flags[fmt.Sprintf("%s-subnet-mask", name)]
+	if strings.Contains(value, "/") {
+		if hasMask {
+			return "", fmt.Errorf("param %s already has prefix, conflicts with %s-subnet-mask", name, name)
+		}
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return "", fmt.Errorf("invalid %s: %s", name, value)
+		}
+		return value, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return "", fmt.Errorf("invalid %s: %s", name, value)
+	}
+	if !hasMask {
+		return value, nil
+	}
+	if ip.To4() == nil {
+		// IPv6的子网掩码只支持前缀长度。
+		if length, err := strconv.Atoi(mask); err != nil || length < 0 || length > 128 {
+			return "", fmt.Errorf("invalid %s-subnet-mask: %s", name, mask)
+		}
+	} else if length, err := strconv.Atoi(mask); err == nil {
+		if length < 0 || length > 32 {
+			return "", fmt.Errorf("invalid %s-subnet-mask: %s", name, mask)
+		}
+	} else if maskIP := net.ParseIP(mask).To4(); maskIP == nil {
+		return "", fmt.Errorf("invalid %s-subnet-mask: %s", name, mask)
+	}
+	return fmt.Sprintf("%s/%s", value, mask), nil
+}
+
+// isIPv6 判断过滤条件中的地址是否为IPv6地址。
+func isIPv6(address string) bool {
+	return strings.Contains(address, ":")
+}
+
+// filterProtocols 获取过滤条件匹配的协议。未指定--protocol时由地址判断，只有端口时同时匹配IPv4与IPv6。
+func filterProtocols(flags map[string]string) ([]string, error) {
+	var families []string
+	for _, name := range []string{"source", "destination"} {
+		if _, ok := flags[name]; !ok {
+			// 只有子网掩码时没有可匹配的地址，会使故障作用于全部报文。
+			if _, hasMask := flags[fmt.Sprintf("%s-subnet-mask", name)]; hasMask {
+				return nil, fmt.Errorf("param %s-subnet-mask requires %s", name, name)
+			}
+			continue
+		}
+		address, err := filterAddress(flags, name)
+		if err != nil {
+			return nil, err
+		}
+		family := protocolIPv4
+		if isIPv6(address) {
+			family = protocolIPv6
+		}
+		if len(families) != 0 && families[0] != family {
+			return nil, fmt.Errorf("source and destination address family mismatch")
+		}
+		families = []string{family}
+	}
+
+	protocol, ok := flags["protocol"]
+	switch {
+	case !ok && len(families) != 0:
+		return families, nil
+	case !ok || protocol == protocolAll:
+		if len(families) != 0 {
+			return nil, fmt.Errorf("protocol %s conflicts with %s address", protocolAll, families[0])
+		}
+		return []string{protocolIPv4, protocolIPv6}, nil
+	case protocol == protocolIPv4 || protocol == protocolIPv6:
+		if len(families) != 0 && families[0] != protocol {
+			return nil, fmt.Errorf("protocol %s conflicts with %s address", protocol, families[0])
+		}
+		return []string{protocol}, nil
+	default:
+		return nil, fmt.Errorf("invalid protocol: %s, support: %s, %s, %s", protocol, protocolIPv4, protocolIPv6, protocolAll)
+	}
+}
+
+// validateFilters 校验报文过滤条件。
+func (b *baseInfo) validateFilters() error {
+	if filterKey(b.flags) == "" {
+		return nil
+	}
+	for _, name := range []string{"source-port", "destination-port"} {
+		if value, ok := b.flags[name]; ok {
+			if port, err := strconv.Atoi(value); err != nil || port < 0 || port > 65535 {
+				return fmt.Errorf("invalid %s: %s", name, value)
+			}
+		}
+	}
+	_, err := filterProtocols(b.flags)
+	return err
+}
+
+// filterCmds 生成将匹配过滤条件的报文分类到band的tc filter命令，每个协议一条，
+// IPv6的filter优先级排在所有IPv4的filter之后。
+func filterCmds(nicDevice string, prioHandle string, pref int, band string, flags map[string]string) []string {
+	protocols, err := filterProtocols(flags)
+	if err != nil {
+		// 注入前已校验过滤条件。
+		return nil
+	}
+
+	var shellCommands []string
+	for _, protocol := range protocols {
+		// tc filter add dev ens18 protocol ip parent a0: prio 1 u32
+		// match ip dst 10.103.176.207 match ip dport 22 0xffff flowid a0:2
+		tcProtocol, match, protocolPref := "ip", "ip", pref
+		if protocol == protocolIPv6 {
+			tcProtocol, match, protocolPref = "ipv6", "ip6", pref+maxPrioBands
+		}
+		cmd := fmt.Sprintf("tc filter add dev %s protocol %s parent %s prio %d u32",
+			nicDevice, tcProtocol, prioHandle, protocolPref)
+		matched := false
+		for _, name := range filterFlags {
+			if _, ok := flags[name]; !ok {
+				continue
+			}
+			switch name {
+			case "source":
+				address, _ := filterAddress(flags, name)
+				cmd = fmt.Sprintf("%s match %s src %s", cmd, match, address)
+			case "destination":
+				address, _ := filterAddress(flags, name)
+				cmd = fmt.Sprintf("%s match %s dst %s", cmd, match, address)
+			case "source-port":
+				cmd = fmt.Sprintf("%s match %s sport %s 0xffff", cmd, match, flags[name])
+			case "destination-port":
+				cmd = fmt.Sprintf("%s match %s dport %s 0xffff", cmd, match, flags[name])
+			}
+			matched = true
+		}
+		// 只指定协议时匹配该协议的全部报文。
+		if !matched {
+			cmd = fmt.Sprintf("%s match u32 0 0", cmd)
+		}
+		shellCommands = append(shellCommands, fmt.Sprintf("%s flowid %s", cmd, band))
+	}
+	return shellCommands
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		want    string
+		wantErr string
+	}{
+		{name: "ipv4", flags: map[string]string{"source": "10.0.0.1"}, want: "10.0.0.1"},
+		{name: "ipv4 cidr", flags: map[string]string{"source": "10.0.0.0/8"}, want: "10.0.0.0/8"},
+		{name: "prefix length", flags: map[string]string{"source": "10.0.0.0", "source-subnet-mask": "16"}, want: "10.0.0.0/16"},
+		{name: "dotted mask", flags: map[string]string{"source": "10.0.0.0", "source-subnet-mask": "255.255.0.0"},
+			want: "10.0.0.0/255.255.0.0"},
+		{name: "ipv6", flags: map[string]string{"source": "2001:db8::1"}, want: "2001:db8::1"},
+		{name: "ipv6 cidr", flags: map[string]string{"source": "2001:db8::/32"}, want: "2001:db8::/32"},
+		{name: "ipv6 prefix length", flags: map[string]string{"source": "2001:db8::", "source-subnet-mask": "64"},
+			want: "2001:db8::/64"},
+		{name: "invalid address", flags: map[string]string{"source": "10.0.0"}, wantErr: "invalid source: 10.0.0"},
+		{name: "invalid cidr", flags: map[string]string{"source": "10.0.0.0/33"}, wantErr: "invalid source: 10.0.0.0/33"},
+		{name: "cidr with mask", flags: map[string]string{"source": "10.0.0.0/8", "source-subnet-mask": "16"},
+			wantErr: "conflicts with source-subnet-mask"},
+		{name: "ipv4 prefix too long", flags: map[string]string{"source": "10.0.0.0", "source-subnet-mask": "33"},
+			wantErr: "invalid source-subnet-mask: 33"},
+		{name: "ipv6 dotted mask", flags: map[string]string{"source": "2001:db8::", "source-subnet-mask": "255.255.0.0"},
+			wantErr: "invalid source-subnet-mask: 255.255.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filterAddress(tt.flags, "source")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("filterAddress() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("filterAddress() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		wantErr string
+	}{
+		{name: "no filter", flags: map[string]string{"delay": "100ms"}},
+		{name: "port only", flags: map[string]string{"destination-port": "80"}},
+		{name: "invalid port", flags: map[string]string{"destination-port": "65536"}, wantErr: "invalid destination-port: 65536"},
+		{name: "mask without address", flags: map[string]string{"source-subnet-mask": "24"},
+			wantErr: "param source-subnet-mask requires source"},
+		{name: "mask with other address", flags: map[string]string{"source": "10.0.0.1", "destination-subnet-mask": "24"},
+			wantErr: "param destination-subnet-mask requires destination"},
+		{name: "family mismatch", flags: map[string]string{"source": "10.0.0.1", "destination": "2001:db8::1"},
+			wantErr: "address family mismatch"},
+		{name: "protocol conflicts with address", flags: map[string]string{"source": "10.0.0.1", "protocol": protocolIPv6},
+			wantErr: "protocol ipv6 conflicts with ipv4 address"},
+		{name: "invalid protocol", flags: map[string]string{"protocol": "tcp"}, wantErr: "invalid protocol: tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &baseInfo{flags: tt.flags}
+			err := b.validateFilters()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateFilters() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateFilters() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterCmds(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags map[string]string
+		want  []string
+	}{
+		{
+			name:  "ipv4 address and port",
+			flags: map[string]string{"destination": "10.0.0.0", "destination-subnet-mask": "24", "destination-port": "22"},
+			want: []string{"tc filter add dev eth0 protocol ip parent a0: prio 2 u32 " +
+				"match ip dst 10.0.0.0/24 match ip dport 22 0xffff flowid a0:3"},
+		},
+		{
+			name:  "ipv6 source",
+			flags: map[string]string{"source": "2001:db8::/32", "source-port": "443"},
+			want: []string{"tc filter add dev eth0 protocol ipv6 parent a0: prio 18 u32 " +
+				"match ip6 src 2001:db8::/32 match ip6 sport 443 0xffff flowid a0:3"},
+		},
+		{
+			name:  "port matches both families",
+			flags: map[string]string{"destination-port": "80"},
+			want: []string{
+				"tc filter add dev eth0 protocol ip parent a0: prio 2 u32 match ip dport 80 0xffff flowid a0:3",
+				"tc filter add dev eth0 protocol ipv6 parent a0: prio 18 u32 match ip6 dport 80 0xffff flowid a0:3",
+			},
+		},
+		{
+			name:  "port with protocol",
+			flags: map[string]string{"destination-port": "80", "protocol": protocolIPv6},
+			want: []string{
+				"tc filter add dev eth0 protocol ipv6 parent a0: prio 18 u32 match ip6 dport 80 0xffff flowid a0:3",
+			},
+		},
+		{
+			name:  "protocol only",
+			flags: map[string]string{"protocol": protocolIPv4},
+			want:  []string{"tc filter add dev eth0 protocol ip parent a0: prio 2 u32 match u32 0 0 flowid a0:3"},
+		},
+		{
+			name:  "mask without address",
+			flags: map[string]string{"source-subnet-mask": "24"},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterCmds("eth0", "a0:", 2, "a0:3", tt.flags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterCmds() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
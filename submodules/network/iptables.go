@@ -38,6 +38,8 @@ type iptablesCtl struct {
 }
 
 func (i *iptablesCtl) iptablesCtlParamsInit(inputArgs []string) error {
+	// 故障处理对象是单例，清除上一次调用留下的参数。
+	*i = iptablesCtl{}
 	i.opsType = inputArgs[submodules.OpsTypeIndex]
 
 	flags := parse.TransInputFlagsToMap(inputArgs)
@@ -70,6 +72,13 @@ func (i *iptablesCtl) dependentsCmdCheck() error {
 	return nil
 }
 
+// preflight 检查iptables故障依赖的权限。
+func (i *iptablesCtl) preflight(faultType string) error {
+	preflight := util.NewPreflight(faultType)
+	preflight.RequireCapability(util.CapNetAdmin)
+	return preflight.Check()
+}
+
 func (i *iptablesCtl) removeArgValue(input string, arg string) string {
 	// 使用正则表达式匹配参数及其后的值，并替换为空字符串。
 	re := regexp.MustCompile(fmt.Sprintf(`%s\s+\S+`, arg))
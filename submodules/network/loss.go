@@ -43,3 +43,15 @@ func (l *loss) FaultInject(_ []string) error {
 func (l *loss) FaultRemove(_ []string) error {
 	return l.base.Executor()
 }
+
+func (l *loss) FaultVerify(_ []string) (string, error) {
+	return l.base.Verify()
+}
+
+func (l *loss) NeedWorker(_ []string) bool {
+	return l.base.NeedRamp()
+}
+
+func (l *loss) FaultWork(_ []string, stop <-chan struct{}) error {
+	return l.base.Ramp(stop)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// lossRandom 独立随机丢包，使用--percent指定丢包率。
+	lossRandom = "random"
+	// lossState 4状态马尔可夫丢包模型。
+	lossState = "state"
+	// lossGemodel Gilbert-Elliott丢包模型。
+	lossGemodel = "gemodel"
+)
+
+// lossModelParams 丢包模型的参数，按tc netem的参数顺序排列。
+var lossModelParams = map[string][]string{
+	// p13好状态进入突发丢包的概率，p31突发丢包回到好状态的概率，p32突发丢包进入坏状态的概率，
+	// p23坏状态回到突发丢包的概率，p14好状态进入孤立丢包的概率。
+	lossState: {"p13", "p31", "p32", "p23", "p14"},
+	// p好状态进入坏状态的概率，r坏状态回到好状态的概率，1-h坏状态的丢包率，1-k好状态的丢包率。
+	lossGemodel: {"p", "r", "1-h", "1-k"},
+}
+
+// lossPreset 丢包模型预设。
+type lossPreset struct {
+	Model  string
+	Params map[string]string
+}
+
+// lossPresets 内置的丢包模型预设，使用--preset指定。
+var lossPresets = map[string]lossPreset{
+	// bursty-wifi 无线信号受干扰时的突发丢包，进入坏状态后连续丢失多数报文。
+	"bursty-wifi": {Model: lossGemodel, Params: map[string]string{"p": "2%", "r": "20%", "1-h": "60%", "1-k": "0.1%"}},
+	// flaky-cable 接触不良的网线，偶尔出现持续数个报文的完全中断以及零星的孤立丢包。
+	"flaky-cable": {Model: lossState, Params: map[string]string{
+		"p13": "0.5%", "p31": "40%", "p32": "5%", "p23": "30%", "p14": "0.1%"}},
+	// congested-link 拥塞链路的队列溢出，好状态下也有少量丢包。
+	"congested-link": {Model: lossGemodel, Params: map[string]string{"p": "5%", "r": "50%", "1-h": "20%", "1-k": "0.5%"}},
+}
+
+// presetNames 获取内置丢包模型预设的名称。
+func presetNames() []string {
+	var names []string
+	for name := range lossPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lossModel 获取丢包模型与按顺序排列的模型参数，单独指定的参数覆盖--preset中的预设值。
+// 模型参数只能省略末尾的若干个，由内核使用默认值。
+func lossModel(flags map[string]string) (string, []string, error) {
+	model := flags["model"]
+	params := make(map[string]string)
+	if presetName, ok := flags["preset"]; ok {
+		preset, ok := lossPresets[presetName]
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported loss preset: %s, support: %s",
+				presetName, strings.Join(presetNames(), ", "))
+		}
+		if model != "" && model != preset.Model {
+			return "", nil, fmt.Errorf("loss preset %s uses model %s, not %s", presetName, preset.Model, model)
+		}
+		model = preset.Model
+		for name, value := range preset.Params {
+			params[name] = value
+		}
+	}
+	if model == "" || model == lossRandom {
+		return lossRandom, nil, nil
+	}
+
+	names, ok := lossModelParams[model]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported loss model: %s, support: %s, %s, %s", model, lossRandom, lossState, lossGemodel)
+	}
+	for _, name := range names {
+		if value, ok := flags[name]; ok {
+			params[name] = value
+		}
+	}
+	var values []string
+	for _, name := range names {
+		value, ok := params[name]
+		if !ok {
+			break
+		}
+		if err := checkNetemPercent(name, value); err != nil {
+			return "", nil, err
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return "", nil, fmt.Errorf("missing param: %s", names[0])
+	}
+	for _, name := range names[len(values):] {
+		if _, ok := params[name]; ok {
+			return "", nil, fmt.Errorf("param %s requires %s", name, names[len(values)])
+		}
+	}
+	return model, values, nil
+}
+
+// validateLossModel 校验丢包模型参数，以及已安装的tc是否支持该模型。
+func (b *baseInfo) validateLossModel() error {
+	var modelFlags []string
+	for _, name := range []string{"model", "preset"} {
+		if _, ok := b.flags[name]; ok {
+			modelFlags = append(modelFlags, name)
+		}
+	}
+	if len(modelFlags) == 0 {
+		return nil
+	}
+	if b.faultType != "loss" {
+		return fmt.Errorf("param %s not supported by network-%s", modelFlags[0], b.faultType)
+	}
+
+	model, _, err := lossModel(b.flags)
+	if err != nil || model == lossRandom {
+		return err
+	}
+	if _, ok := b.flags["correlation"]; ok {
+		return fmt.Errorf("param correlation not supported by loss model %s", model)
+	}
+	usage, err := netemUsage()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(usage, fmt.Sprintf("loss %s", model)) {
+		return fmt.Errorf("installed tc does not support loss model %s", model)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLossModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		flags      map[string]string
+		wantModel  string
+		wantValues []string
+		wantErr    string
+	}{
+		{name: "default random", flags: map[string]string{"percent": "10%"}, wantModel: lossRandom},
+		{name: "explicit random", flags: map[string]string{"model": lossRandom, "percent": "10%"}, wantModel: lossRandom},
+		{
+			name:       "gemodel",
+			flags:      map[string]string{"model": lossGemodel, "p": "1%", "r": "10%", "1-h": "70%", "1-k": "0.1%"},
+			wantModel:  lossGemodel,
+			wantValues: []string{"1%", "10%", "70%", "0.1%"},
+		},
+		{
+			name:       "state with trailing params omitted",
+			flags:      map[string]string{"model": lossState, "p13": "1%", "p31": "50%"},
+			wantModel:  lossState,
+			wantValues: []string{"1%", "50%"},
+		},
+		{
+			name:       "preset",
+			flags:      map[string]string{"preset": "bursty-wifi"},
+			wantModel:  lossGemodel,
+			wantValues: []string{"2%", "20%", "60%", "0.1%"},
+		},
+		{
+			name:       "preset overridden",
+			flags:      map[string]string{"preset": "flaky-cable", "p14": "0.2%"},
+			wantModel:  lossState,
+			wantValues: []string{"0.5%", "40%", "5%", "30%", "0.2%"},
+		},
+		{name: "unknown preset", flags: map[string]string{"preset": "lossy"}, wantErr: "unsupported loss preset: lossy"},
+		{
+			name:    "preset model mismatch",
+			flags:   map[string]string{"preset": "bursty-wifi", "model": lossState},
+			wantErr: "loss preset bursty-wifi uses model gemodel, not state",
+		},
+		{name: "unknown model", flags: map[string]string{"model": "burst"}, wantErr: "unsupported loss model: burst"},
+		{name: "missing first param", flags: map[string]string{"model": lossGemodel, "r": "10%"}, wantErr: "missing param: p"},
+		{name: "no params", flags: map[string]string{"model": lossGemodel}, wantErr: "missing param: p"},
+		{name: "gap in params", flags: map[string]string{"model": lossGemodel, "p": "1%", "1-h": "70%"},
+			wantErr: "param 1-h requires r"},
+		{name: "invalid percent", flags: map[string]string{"model": lossGemodel, "p": "101%"}, wantErr: "invalid p: 101%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, values, err := lossModel(tt.flags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("lossModel() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lossModel() error = %v", err)
+			}
+			if model != tt.wantModel || !reflect.DeepEqual(values, tt.wantValues) {
+				t.Errorf("lossModel() = %s %v, want %s %v", model, values, tt.wantModel, tt.wantValues)
+			}
+		})
+	}
+}
+
+func TestLossModelOptions(t *testing.T) {
+	fault := &netemFault{FaultType: "loss", Flags: map[string]string{"preset": "congested-link"}}
+	want := []netemOption{{Name: "loss", Value: "gemodel 5% 50% 20% 0.5%"}}
+	if got, err := fault.options(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("options() = %+v, %v, want %+v", got, err, want)
+	}
+}
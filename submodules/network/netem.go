@@ -0,0 +1,479 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// maxPrioBands prio qdisc支持的最大band数。
+const maxPrioBands = 16
+
+// netemOptionOrder netem参数合并后的顺序，
+// 其中tbf不是netem的参数，表示在netem下挂载tbf限制带宽。
+var netemOptionOrder = []string{"delay", "distribution", "loss", "corrupt", "duplicate", "reorder", "gap", "rate", "tbf"}
+
+// filterExtraFlags 过滤条件的子网掩码与协议参数，同样用于区分故障的过滤条件。
+var filterExtraFlags = []string{"source-subnet-mask", "destination-subnet-mask", "protocol"}
+
+// netemFault 网卡上一个处于注入状态的netem故障。
+type netemFault struct {
+	FaultType string            `json:"fault-type"`
+	Flags     map[string]string `json:"flags"`
+	// Reserved 故障运行过程中会设置的netem参数，如：轨迹中后续采样点才出现的loss，注入时即检查冲突。
+	Reserved []string `json:"reserved,omitempty"`
+}
+
+// netemOption netem的一个参数，如：delay 100ms。
+type netemOption struct {
+	Name  string
+	Value string
+}
+
+// netemGroup 过滤条件相同的故障，合并为一个netem。
+type netemGroup struct {
+	// Key 过滤条件，为空表示匹配全部报文。
+	Key     string
+	Faults  []*netemFault
+	Options []netemOption
+}
+
+// netemPlacement 一个netem在网卡上的位置。
+type netemPlacement struct {
+	Parent string
+	Handle string
+	// TbfHandle 挂载在netem下的tbf的handle，分组不使用tbf限制带宽时为空。
+	TbfHandle string
+	Group     *netemGroup
+}
+
+// filterKey 生成故障过滤条件的标识，过滤条件相同的故障合并到同一个netem。
+func filterKey(flags map[string]string) string {
+	var parts []string
+	for _, name := range append(append([]string{}, filterFlags...), filterExtraFlags...) {
+		if value, ok := flags[name]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// id 故障的标识，同一网卡上故障模式与过滤条件相同的故障只能注入一次。
+func (f *netemFault) id() string {
+	return fmt.Sprintf("%s[%s]", f.FaultType, filterKey(f.Flags))
+}
+
+// name 故障名称，如：network-delay。
+func (f *netemFault) name() string {
+	name := fmt.Sprintf("network-%s", f.FaultType)
+	if key := filterKey(f.Flags); key != "" {
+		name = fmt.Sprintf("%s(%s)", name, key)
+	}
+	return name
+}
+
+// delayOptions 生成delay参数，如：delay 100ms 10ms 25% distribution normal。
+func (f *netemFault) delayOptions() ([]netemOption, error) {
+	timeStr, ok := f.Flags["delay"]
+	if !ok {
+		return nil, fmt.Errorf("missing param: delay")
+	}
+	if jitter, ok := f.Flags["jitter"]; ok {
+		timeStr = fmt.Sprintf("%s %s", timeStr, jitter)
+		if correlation, ok := f.Flags["correlation"]; ok {
+			timeStr = fmt.Sprintf("%s %s", timeStr, correlation)
+		}
+	}
+	options := []netemOption{{Name: "delay", Value: timeStr}}
+	if distribution, ok := f.Flags["distribution"]; ok {
+		options = append(options, netemOption{Name: "distribution", Value: distribution})
+	}
+	return options, nil
+}
+
+// options 根据故障模式生成netem参数，如：delay 100ms、loss 10% 25%。
+func (f *netemFault) options() ([]netemOption, error) {
+	switch f.FaultType {
+	case "loss", "corrupt", "duplicate":
+		if f.FaultType == "loss" {
+			model, values, err := lossModel(f.Flags)
+			if err != nil {
+				return nil, err
+			}
+			if model != lossRandom {
+				return []netemOption{{Name: "loss", Value: fmt.Sprintf("%s %s", model, strings.Join(values, " "))}}, nil
+			}
+		}
+		percentStr, ok := f.Flags["percent"]
+		if !ok {
+			return nil, fmt.Errorf("missing param: percent")
+		}
+		if correlation, ok := f.Flags["correlation"]; ok {
+			percentStr = fmt.Sprintf("%s %s", percentStr, correlation)
+		}
+		return []netemOption{{Name: f.FaultType, Value: percentStr}}, nil
+	case "delay":
+		return f.delayOptions()
+	case "reorder":
+		options, err := f.delayOptions()
+		if err != nil {
+			return nil, err
+		}
+		percentStr, ok := f.Flags["percent"]
+		if !ok {
+			return nil, fmt.Errorf("missing param: percent")
+		}
+		relatperStr, ok := f.Flags["relatper"]
+		if !ok {
+			return nil, fmt.Errorf("missing param: relatper")
+		}
+		options = append(options, netemOption{Name: "reorder", Value: fmt.Sprintf("%s %s", percentStr, relatperStr)})
+		if gap, ok := f.Flags["gap"]; ok {
+			options = append(options, netemOption{Name: "gap", Value: gap})
+		}
+		return options, nil
+	case "rate":
+		return f.rateOptions()
+	case "trace", "profile":
+		return f.conditionOptions(), nil
+	default:
+		return nil, fmt.Errorf("unsupported fault type: %s", f.FaultType)
+	}
+}
+
+// rateOptions 生成带宽限制参数，如：rate 10mbit 20 1500、tbf rate 10mbit burst 32kb latency 50ms。
+func (f *netemFault) rateOptions() ([]netemOption, error) {
+	rate, ok := f.Flags["rate"]
+	if !ok {
+		return nil, fmt.Errorf("missing param: rate")
+	}
+	if f.Flags["shaper"] == shaperTbf {
+		burst, latency := defaultTbfBurst, defaultTbfLatency
+		if value, ok := f.Flags["burst"]; ok {
+			burst = value
+		}
+		if value, ok := f.Flags["latency"]; ok {
+			latency = value
+		}
+		return []netemOption{{Name: "tbf", Value: fmt.Sprintf("rate %s burst %s latency %s", rate, burst, latency)}}, nil
+	}
+	// netem rate的可选参数按位置解析，只能省略末尾的若干个。
+	for _, name := range []string{"packet-overhead", "cell-size", "cell-overhead"} {
+		value, ok := f.Flags[name]
+		if !ok {
+			break
+		}
+		rate = fmt.Sprintf("%s %s", rate, value)
+	}
+	return []netemOption{{Name: "rate", Value: rate}}, nil
+}
+
+// optionOwner 获取参数冲突判断时使用的名称，netem rate与tbf都限制带宽，不能同时设置。
+func optionOwner(name string) string {
+	if name == "tbf" {
+		return "rate"
+	}
+	return name
+}
+
+// mergeOptions 合并多个故障的netem参数，同一参数只能由一个故障设置。
+// base中的参数来自匹配全部报文的故障，与faults中的参数重复时以faults为准。
+func mergeOptions(base []netemOption, faults []*netemFault) ([]netemOption, error) {
+	values := make(map[string]string)
+	owners := make(map[string]*netemFault)
+	for _, fault := range faults {
+		options, err := fault.options()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fault.name(), err)
+		}
+		for _, option := range options {
+			ownerName := optionOwner(option.Name)
+			if owner, ok := owners[ownerName]; ok {
+				return nil, fmt.Errorf("%s conflicts with %s on netem param %s", fault.name(), owner.name(), ownerName)
+			}
+			values[option.Name], owners[ownerName] = option.Value, fault
+		}
+		for _, ownerName := range fault.Reserved {
+			if owner, ok := owners[ownerName]; ok && owner != fault {
+				return nil, fmt.Errorf("%s conflicts with %s on netem param %s", fault.name(), owner.name(), ownerName)
+			}
+			owners[ownerName] = fault
+		}
+	}
+	for _, option := range base {
+		if _, ok := owners[optionOwner(option.Name)]; !ok {
+			values[option.Name] = option.Value
+		}
+	}
+
+	var merged []netemOption
+	for _, name := range netemOptionOrder {
+		if value, ok := values[name]; ok {
+			merged = append(merged, netemOption{Name: name, Value: value})
+		}
+	}
+	return merged, nil
+}
+
+// netemArgs 生成netem命令参数，如：delay 100ms loss 10%。
+func netemArgs(options []netemOption) string {
+	var parts []string
+	for _, option := range options {
+		if option.Name != "tbf" {
+			parts = append(parts, option.Name, option.Value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// tbfArgs 获取挂载在netem下的tbf参数，不使用tbf时返回空。
+func tbfArgs(options []netemOption) string {
+	for _, option := range options {
+		if option.Name == "tbf" {
+			return option.Value
+		}
+	}
+	return ""
+}
+
+// groups 将故障按过滤条件分组，返回匹配全部报文的分组与各个带过滤条件的分组。
+// 带过滤条件的报文不再经过匹配全部报文的netem，因此匹配全部报文的故障参数同时合并到每个带过滤条件的分组。
+func (s *tcState) groups() (*netemGroup, []*netemGroup, error) {
+	var all *netemGroup
+	var filtered []*netemGroup
+	groupMap := make(map[string]*netemGroup)
+	for _, fault := range s.Faults {
+		key := filterKey(fault.Flags)
+		group, ok := groupMap[key]
+		if !ok {
+			group = &netemGroup{Key: key}
+			groupMap[key] = group
+			if key == "" {
+				all = group
+			} else {
+				filtered = append(filtered, group)
+			}
+		}
+		group.Faults = append(group.Faults, fault)
+	}
+	if len(filtered) > maxPrioBands-1 {
+		return nil, nil, fmt.Errorf("too many netem filters on %s, at most %d", s.Interface, maxPrioBands-1)
+	}
+
+	var err error
+	if all != nil {
+		if all.Options, err = mergeOptions(nil, all.Faults); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, group := range filtered {
+		var base []netemOption
+		if all != nil {
+			base = all.Options
+		}
+		if group.Options, err = mergeOptions(base, group.Faults); err != nil {
+			return nil, nil, err
+		}
+	}
+	return all, filtered, nil
+}
+
+// layout 生成在各挂载位置上挂载故障qdisc的tc命令与各netem的位置。
+// 只有匹配全部报文的故障时netem直接挂在挂载位置上；否则挂载prio，第1个band挂载匹配全部报文的netem，
+// 其后每个band挂载一个带过滤条件的netem，由tc filter将匹配的报文分类到对应的band。
+func (s *tcState) layout() ([]string, []netemPlacement, error) {
+	all, filtered, err := s.groups()
+	if err != nil {
+		return nil, nil, err
+	}
+	if all == nil && len(filtered) == 0 {
+		return nil, nil, nil
+	}
+
+	var shellCommands []string
+	var placements []netemPlacement
+	if s.Mq != "" {
+		shellCommands = append(shellCommands, fmt.Sprintf("tc qdisc replace dev %s root handle %s mq",
+			s.Interface, s.Mq))
+	}
+	// 每个挂载位置依次使用prio以及匹配全部报文的分组与各个带过滤条件的分组的netem、tbf的handle。
+	perPoint := 3 + 2*len(filtered)
+	handles := allocHandles(s.Used, len(s.Points)*perPoint)
+	for index, point := range s.Points {
+		pointHandles := handles[index*perPoint : (index+1)*perPoint]
+		if len(filtered) == 0 {
+			shellCommands = append(shellCommands, s.netemCommands(fmt.Sprintf("replace dev %s %s", s.Interface,
+				location(point.Parent)), pointHandles[0], pointHandles[2], all)...)
+			placements = append(placements, newPlacement(point.Parent, pointHandles[0], pointHandles[2], all))
+			continue
+		}
+
+		// tc qdisc replace dev ens18 root handle a0: prio bands 3 priomap 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+		prioHandle := pointHandles[0]
+		shellCommands = append(shellCommands, fmt.Sprintf("tc qdisc replace dev %s %s handle %s prio bands %d priomap %s",
+			s.Interface, location(point.Parent), prioHandle, len(filtered)+1, strings.TrimSpace(strings.Repeat("0 ", 16))))
+		if all != nil {
+			// tc qdisc add dev ens18 parent a0:1 handle a1: netem delay 100ms
+			band := fmt.Sprintf("%s1", prioHandle)
+			shellCommands = append(shellCommands, s.netemCommands(fmt.Sprintf("add dev %s parent %s", s.Interface, band),
+				pointHandles[1], pointHandles[2], all)...)
+			placements = append(placements, newPlacement(band, pointHandles[1], pointHandles[2], all))
+		}
+		for i, group := range filtered {
+			// tc qdisc add dev ens18 parent a0:2 handle a3: netem loss 20%
+			band := fmt.Sprintf("%s%x", prioHandle, i+2)
+			netemHandle, tbfHandle := pointHandles[3+2*i], pointHandles[4+2*i]
+			shellCommands = append(shellCommands, s.netemCommands(fmt.Sprintf("add dev %s parent %s", s.Interface, band),
+				netemHandle, tbfHandle, group)...)
+			shellCommands = append(shellCommands, filterCmds(s.Interface, prioHandle, i+1, band, group.Faults[0].Flags)...)
+			placements = append(placements, newPlacement(band, netemHandle, tbfHandle, group))
+		}
+	}
+	return shellCommands, placements, nil
+}
+
+func newPlacement(parent string, handle string, tbfHandle string, group *netemGroup) netemPlacement {
+	placement := netemPlacement{Parent: parent, Handle: handle, Group: group}
+	if tbfArgs(group.Options) != "" {
+		placement.TbfHandle = tbfHandle
+	}
+	return placement
+}
+
+// netemCommands 生成挂载分组netem的tc命令，分组使用tbf限制带宽时tbf挂载在netem下。
+func (s *tcState) netemCommands(position string, handle string, tbfHandle string, group *netemGroup) []string {
+	shellCommands := []string{strings.TrimSpace(fmt.Sprintf("tc qdisc %s handle %s netem %s",
+		position, handle, netemArgs(group.Options)))}
+	if args := tbfArgs(group.Options); args != "" {
+		// tc qdisc add dev ens18 parent a1:1 handle a2: tbf rate 10mbit burst 32kb latency 50ms
+		shellCommands = append(shellCommands, fmt.Sprintf("tc qdisc add dev %s parent %s1 handle %s tbf %s",
+			s.Interface, handle, tbfHandle, args))
+	}
+	return shellCommands
+}
+
+// find 查找标识为id的故障。
+func (s *tcState) find(id string) *netemFault {
+	for _, fault := range s.Faults {
+		if fault.id() == id {
+			return fault
+		}
+	}
+	return nil
+}
+
+// without 获取去掉标识为id的故障后的故障列表。
+func (s *tcState) without(id string) []*netemFault {
+	var faults []*netemFault
+	for _, fault := range s.Faults {
+		if fault.id() != id {
+			faults = append(faults, fault)
+		}
+	}
+	return faults
+}
+
+// update 将网卡上的netem故障更新为faults：先恢复原有的qdisc再按新的故障列表重建，
+// 故障列表为空时删除状态文件，执行失败时恢复到更新前的故障列表。
+func (s *tcState) update(faults []*netemFault) error {
+	next := *s
+	next.Faults = faults
+	applyCommands, _, err := next.layout()
+	if err != nil {
+		return err
+	}
+	previousCommands, _, err := s.layout()
+	if err != nil {
+		return err
+	}
+
+	var shellCommands []string
+	if len(s.Faults) != 0 {
+		shellCommands = s.restoreCommands()
+	}
+	shellCommands = append(shellCommands, applyCommands...)
+	// 先保存状态再修改qdisc，进程异常退出时仍可以清理。
+	if len(faults) != 0 {
+		if err := saveTcState(&next); err != nil {
+			return err
+		}
+	}
+
+	if err := execTcCommands(shellCommands); err != nil {
+		if restoreErr := execTcCommands(append(s.restoreCommands(), previousCommands...)); restoreErr != nil {
+			return fmt.Errorf("%v, restore qdisc failed: %v", err, restoreErr)
+		}
+		if len(s.Faults) != 0 {
+			if saveErr := saveTcState(s); saveErr != nil {
+				return fmt.Errorf("%v, %v", err, saveErr)
+			}
+		} else if removeErr := removeTcState(s.Interface); removeErr != nil {
+			return fmt.Errorf("%v, %v", err, removeErr)
+		}
+		return err
+	}
+	if len(faults) == 0 {
+		return removeTcState(s.Interface)
+	}
+	return nil
+}
+
+// changeCommands 生成原地修改故障所在netem与tbf参数的tc命令，匹配全部报文的故障同时修改所有netem。
+func (s *tcState) changeCommands(fault *netemFault) ([]string, error) {
+	_, placements, err := s.layout()
+	if err != nil {
+		return nil, err
+	}
+	key := filterKey(fault.Flags)
+	var shellCommands []string
+	for _, placement := range placements {
+		if key != "" && placement.Group.Key != key {
+			continue
+		}
+		shellCommands = append(shellCommands, strings.TrimSpace(fmt.Sprintf("tc qdisc change dev %s %s handle %s netem %s",
+			s.Interface, location(placement.Parent), placement.Handle, netemArgs(placement.Group.Options))))
+		if placement.TbfHandle != "" {
+			shellCommands = append(shellCommands, fmt.Sprintf("tc qdisc change dev %s parent %s1 handle %s tbf %s",
+				s.Interface, placement.Handle, placement.TbfHandle, tbfArgs(placement.Group.Options)))
+		}
+	}
+	return shellCommands, nil
+}
+
+// lockInterface 对网卡的netem故障状态加锁，避免并发注入、清理与参数渐变互相覆盖，返回解锁函数。
+func lockInterface(nicDevice string) (func(), error) {
+	statePath, err := getTcStatePath(nicDevice)
+	if err != nil {
+		return nil, err
+	}
+	lockPath := strings.TrimSuffix(statePath, ".json") + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("open tc lock(%s) failed(%v)", lockPath, err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("lock %s failed(%v)", lockPath, err)
+	}
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMergeOptions(t *testing.T) {
+	delay := &netemFault{FaultType: "delay", Flags: map[string]string{"delay": "100ms", "jitter": "10ms"}}
+	loss := &netemFault{FaultType: "loss", Flags: map[string]string{"percent": "10%"}}
+	tests := []struct {
+		name    string
+		base    []netemOption
+		faults  []*netemFault
+		want    []netemOption
+		wantErr string
+	}{
+		{
+			name: "ordered by netem param",
+			faults: []*netemFault{
+				{FaultType: "rate", Flags: map[string]string{"rate": "10mbit"}},
+				loss,
+				delay,
+			},
+			want: []netemOption{{Name: "delay", Value: "100ms 10ms"}, {Name: "loss", Value: "10%"}, {Name: "rate", Value: "10mbit"}},
+		},
+		{
+			name:   "base overridden by filtered fault",
+			base:   []netemOption{{Name: "delay", Value: "50ms"}, {Name: "corrupt", Value: "1%"}},
+			faults: []*netemFault{delay},
+			want:   []netemOption{{Name: "delay", Value: "100ms 10ms"}, {Name: "corrupt", Value: "1%"}},
+		},
+		{
+			name: "reorder with gap",
+			faults: []*netemFault{{FaultType: "reorder", Flags: map[string]string{
+				"delay": "10ms", "percent": "25%", "relatper": "50%", "gap": "5"}}},
+			want: []netemOption{{Name: "delay", Value: "10ms"}, {Name: "reorder", Value: "25% 50%"}, {Name: "gap", Value: "5"}},
+		},
+		{
+			name: "tbf shaper",
+			faults: []*netemFault{delay, {FaultType: "rate", Flags: map[string]string{
+				"rate": "10mbit", "shaper": shaperTbf, "burst": "64kb"}}},
+			want: []netemOption{{Name: "delay", Value: "100ms 10ms"}, {Name: "tbf", Value: "rate 10mbit burst 64kb latency 50ms"}},
+		},
+		{
+			name:    "same param",
+			faults:  []*netemFault{delay, {FaultType: "reorder", Flags: map[string]string{"delay": "10ms", "percent": "25%", "relatper": "50%"}}},
+			wantErr: "network-reorder conflicts with network-delay on netem param delay",
+		},
+		{
+			name: "netem rate and tbf",
+			faults: []*netemFault{
+				{FaultType: "rate", Flags: map[string]string{"rate": "10mbit"}},
+				{FaultType: "rate", Flags: map[string]string{"rate": "20mbit", "shaper": shaperTbf}},
+			},
+			wantErr: "conflicts with network-rate on netem param rate",
+		},
+		{
+			name:    "reserved by trace",
+			faults:  []*netemFault{{FaultType: "trace", Flags: map[string]string{"delay": "100ms"}, Reserved: []string{"delay", "loss"}}, loss},
+			wantErr: "network-loss conflicts with network-trace on netem param loss",
+		},
+		{
+			name:    "missing param",
+			faults:  []*netemFault{{FaultType: "loss", Flags: map[string]string{}}},
+			wantErr: "network-loss: missing param: percent",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeOptions(tt.base, tt.faults)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("mergeOptions() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	state := &tcState{Interface: "eth0", Faults: []*netemFault{
+		{FaultType: "delay", Flags: map[string]string{"delay": "100ms"}},
+		{FaultType: "loss", Flags: map[string]string{"percent": "10%", "destination": "10.0.0.1"}},
+		{FaultType: "corrupt", Flags: map[string]string{"percent": "1%"}},
+		{FaultType: "delay", Flags: map[string]string{"delay": "200ms", "destination": "10.0.0.1"}},
+		{FaultType: "duplicate", Flags: map[string]string{"percent": "5%", "destination-port": "80"}},
+	}}
+	all, filtered, err := state.groups()
+	if err != nil {
+		t.Fatalf("groups() error = %v", err)
+	}
+	wantAll := []netemOption{{Name: "delay", Value: "100ms"}, {Name: "corrupt", Value: "1%"}}
+	if all == nil || all.Key != "" || !reflect.DeepEqual(all.Options, wantAll) {
+		t.Fatalf("all group = %+v, want options %+v", all, wantAll)
+	}
+	wantFiltered := []struct {
+		key     string
+		options []netemOption
+	}{
+		{key: "destination=10.0.0.1", options: []netemOption{
+			{Name: "delay", Value: "200ms"}, {Name: "loss", Value: "10%"}, {Name: "corrupt", Value: "1%"}}},
+		{key: "destination-port=80", options: []netemOption{
+			{Name: "delay", Value: "100ms"}, {Name: "corrupt", Value: "1%"}, {Name: "duplicate", Value: "5%"}}},
+	}
+	if len(filtered) != len(wantFiltered) {
+		t.Fatalf("got %d filtered groups, want %d", len(filtered), len(wantFiltered))
+	}
+	for i, want := range wantFiltered {
+		if filtered[i].Key != want.key || !reflect.DeepEqual(filtered[i].Options, want.options) {
+			t.Errorf("filtered group %d = %s %+v, want %s %+v", i, filtered[i].Key, filtered[i].Options, want.key, want.options)
+		}
+	}
+}
+
+func TestGroupsTooManyFilters(t *testing.T) {
+	state := &tcState{Interface: "eth0"}
+	for port := 1; port <= maxPrioBands; port++ {
+		state.Faults = append(state.Faults, &netemFault{FaultType: "loss",
+			Flags: map[string]string{"percent": "10%", "destination-port": strconv.Itoa(port)}})
+	}
+	if _, _, err := state.groups(); err == nil || !strings.Contains(err.Error(), "too many netem filters") {
+		t.Errorf("groups() error = %v, want too many netem filters", err)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	delay := &netemFault{FaultType: "delay", Flags: map[string]string{"delay": "100ms"}}
+	filteredLoss := &netemFault{FaultType: "loss", Flags: map[string]string{"percent": "10%", "destination": "10.0.0.1"}}
+	tbfRate := &netemFault{FaultType: "rate", Flags: map[string]string{"rate": "10mbit", "shaper": shaperTbf}}
+	tests := []struct {
+		name           string
+		qdiscOutput    string
+		classOutput    string
+		faults         []*netemFault
+		wantCommands   []string
+		wantPlacements []netemPlacement
+	}{
+		{
+			name:        "default fq_codel",
+			qdiscOutput: defaultFqCodelQdiscOutput,
+			faults:      []*netemFault{delay},
+			wantCommands: []string{
+				"tc qdisc replace dev eth0 root handle a0: netem delay 100ms",
+			},
+			wantPlacements: []netemPlacement{{Parent: "root", Handle: "a0:"}},
+		},
+		{
+			name:        "default mq",
+			qdiscOutput: mqQdiscOutput,
+			classOutput: mqClassOutput,
+			faults:      []*netemFault{delay},
+			wantCommands: []string{
+				"tc qdisc replace dev eth0 root handle a0: mq",
+				"tc qdisc replace dev eth0 parent a0:1 handle a1: netem delay 100ms",
+				"tc qdisc replace dev eth0 parent a0:2 handle a4: netem delay 100ms",
+			},
+			wantPlacements: []netemPlacement{{Parent: "a0:1", Handle: "a1:"}, {Parent: "a0:2", Handle: "a4:"}},
+		},
+		{
+			name:        "fq_codel with tbf",
+			qdiscOutput: fqCodelQdiscOutput,
+			faults:      []*netemFault{delay, tbfRate},
+			wantCommands: []string{
+				"tc qdisc replace dev eth0 root handle a0: netem delay 100ms",
+				"tc qdisc add dev eth0 parent a0:1 handle a2: tbf rate 10mbit burst 32kb latency 50ms",
+			},
+			wantPlacements: []netemPlacement{{Parent: "root", Handle: "a0:", TbfHandle: "a2:"}},
+		},
+		{
+			name:        "htb with leaves and filter",
+			qdiscOutput: htbQdiscOutput,
+			classOutput: htbClassOutput,
+			faults:      []*netemFault{delay, filteredLoss},
+			wantCommands: []string{
+				"tc qdisc replace dev eth0 parent 1:10 handle a0: prio bands 2 priomap 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0",
+				"tc qdisc add dev eth0 parent a0:1 handle a1: netem delay 100ms",
+				"tc qdisc add dev eth0 parent a0:2 handle a3: netem delay 100ms loss 10%",
+				"tc filter add dev eth0 protocol ip parent a0: prio 1 u32 match ip dst 10.0.0.1 flowid a0:2",
+				"tc qdisc replace dev eth0 parent 1:20 handle a5: prio bands 2 priomap 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0",
+				"tc qdisc add dev eth0 parent a5:1 handle a6: netem delay 100ms",
+				"tc qdisc add dev eth0 parent a5:2 handle a8: netem delay 100ms loss 10%",
+				"tc filter add dev eth0 protocol ip parent a5: prio 1 u32 match ip dst 10.0.0.1 flowid a5:2",
+			},
+			wantPlacements: []netemPlacement{
+				{Parent: "a0:1", Handle: "a1:"}, {Parent: "a0:2", Handle: "a3:"},
+				{Parent: "a5:1", Handle: "a6:"}, {Parent: "a5:2", Handle: "a8:"},
+			},
+		},
+		{
+			name:        "tbf with child qdisc and only filtered fault",
+			qdiscOutput: tbfNestedQdiscOutput,
+			classOutput: tbfNestedClassOutput,
+			faults:      []*netemFault{filteredLoss},
+			wantCommands: []string{
+				"tc qdisc replace dev eth0 parent 1:1 handle a0: prio bands 2 priomap 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0",
+				"tc qdisc add dev eth0 parent a0:2 handle a3: netem loss 10%",
+				"tc filter add dev eth0 protocol ip parent a0: prio 1 u32 match ip dst 10.0.0.1 flowid a0:2",
+			},
+			wantPlacements: []netemPlacement{{Parent: "a0:2", Handle: "a3:"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := &qdiscTree{Qdiscs: parseQdiscs(tt.qdiscOutput), Classes: parseClasses(tt.classOutput)}
+			state, err := tree.newState("eth0")
+			if err != nil {
+				t.Fatalf("newState() error = %v", err)
+			}
+			state.Faults = tt.faults
+			commands, placements, err := state.layout()
+			if err != nil {
+				t.Fatalf("layout() error = %v", err)
+			}
+			if !reflect.DeepEqual(commands, tt.wantCommands) {
+				t.Errorf("layout() commands = %q, want %q", commands, tt.wantCommands)
+			}
+			if len(placements) != len(tt.wantPlacements) {
+				t.Fatalf("layout() got %d placements, want %d", len(placements), len(tt.wantPlacements))
+			}
+			for i, want := range tt.wantPlacements {
+				got := placements[i]
+				if got.Parent != want.Parent || got.Handle != want.Handle || got.TbfHandle != want.TbfHandle {
+					t.Errorf("placement %d = %s %s %s, want %s %s %s", i, got.Parent, got.Handle, got.TbfHandle,
+						want.Parent, want.Handle, want.TbfHandle)
+				}
+			}
+		})
+	}
+}
+
+func TestChangeCommands(t *testing.T) {
+	tree := &qdiscTree{Qdiscs: parseQdiscs(htbQdiscOutput), Classes: parseClasses(htbClassOutput)}
+	state, err := tree.newState("eth0")
+	if err != nil {
+		t.Fatalf("newState() error = %v", err)
+	}
+	delay := &netemFault{FaultType: "delay", Flags: map[string]string{"delay": "100ms"}}
+	filteredLoss := &netemFault{FaultType: "loss", Flags: map[string]string{"percent": "10%", "destination": "10.0.0.1"}}
+	state.Faults = []*netemFault{delay, filteredLoss}
+
+	filteredLoss.Flags["percent"] = "20%"
+	want := []string{
+		"tc qdisc change dev eth0 parent a0:2 handle a3: netem delay 100ms loss 20%",
+		"tc qdisc change dev eth0 parent a5:2 handle a8: netem delay 100ms loss 20%",
+	}
+	if got, err := state.changeCommands(filteredLoss); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("changeCommands(loss) = %q, %v, want %q", got, err, want)
+	}
+
+	// 匹配全部报文的故障同时修改带过滤条件的netem。
+	delay.Flags["delay"] = "150ms"
+	want = []string{
+		"tc qdisc change dev eth0 parent a0:1 handle a1: netem delay 150ms",
+		"tc qdisc change dev eth0 parent a0:2 handle a3: netem delay 150ms loss 20%",
+		"tc qdisc change dev eth0 parent a5:1 handle a6: netem delay 150ms",
+		"tc qdisc change dev eth0 parent a5:2 handle a8: netem delay 150ms loss 20%",
+	}
+	if got, err := state.changeCommands(delay); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("changeCommands(delay) = %q, %v, want %q", got, err, want)
+	}
+}
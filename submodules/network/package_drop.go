@@ -40,6 +40,9 @@ func (p *packageDrop) Prepare(inputArgs []string) error {
 	if err := p.iptablesCtl.dependentsCmdCheck(); err != nil {
 		return errors.New("not found iptables command in current environment")
 	}
+	if err := p.iptablesCtl.preflight(p.FaultType); err != nil {
+		return err
+	}
 
 	if err := p.iptablesCtl.iptablesCtlParamsInit(inputArgs); err != nil {
 		return err
@@ -70,3 +73,7 @@ func (p *packageDrop) FaultRemove(inputArgs []string) error {
 	}
 	return p.runShellCmd()
 }
+
+func (p *packageDrop) FaultVerify(_ []string) (string, error) {
+	return p.iptablesCtl.verifyDropRules([]string{p.iptablesCtl.chain}, []string{p.iptablesCtl.shellCmd})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"arsenal-hardware/util"
+)
+
+// optionalNetemParams 可选的netem参数，按该顺序校验。
+var optionalNetemParams = []string{"jitter", "correlation", "distribution", "gap",
+	"packet-overhead", "cell-size", "cell-overhead", "shaper", "burst", "latency"}
+
+// netemParamFaults 可选netem参数适用的故障模式。
+var netemParamFaults = map[string][]string{
+	"jitter":          {"delay", "reorder"},
+	"correlation":     {"delay", "reorder", "loss", "corrupt", "duplicate"},
+	"distribution":    {"delay", "reorder"},
+	"gap":             {"reorder"},
+	"packet-overhead": {"rate"},
+	"cell-size":       {"rate"},
+	"cell-overhead":   {"rate"},
+	"shaper":          {"rate"},
+	"burst":           {"rate"},
+	"latency":         {"rate"},
+}
+
+// netemParamKeywords 可选netem参数在tc netem帮助信息中对应的关键字，tbf的参数不需要检查。
+var netemParamKeywords = map[string]string{
+	"jitter":          "JITTER",
+	"correlation":     "CORRELATION",
+	"distribution":    "distribution",
+	"gap":             "gap",
+	"packet-overhead": "PACKETOVERHEAD",
+	"cell-size":       "CELLSIZE",
+	"cell-overhead":   "CELLOVERHEAD",
+	"rate":            "rate RATE",
+}
+
+// tcLibDirs tc查找分布表的默认目录。
+var tcLibDirs = []string{"/usr/lib/tc", "/usr/lib64/tc", "/lib/tc"}
+
+// netemPercentRegexp 匹配netem的百分比参数，如：25%、0.5。
+var netemPercentRegexp = regexp.MustCompile(`^([0-9]*\.?[0-9]+)%?$`)
+
+// netemUsage 获取已安装tc的netem帮助信息，用于判断支持的参数。
+func netemUsage() (string, error) {
+	// 帮助信息输出后tc以非0退出码返回。
+	result, _ := util.ExecCommandBlock("tc qdisc add netem help")
+	if !strings.Contains(result, "netem") {
+		return "", fmt.Errorf("get tc netem usage failed: %s", strings.TrimSpace(result))
+	}
+	return result, nil
+}
+
+// distributionTables 获取tc可用的netem分布表，包括tc自带的normal、pareto、paretonormal与自定义的分布表。
+func distributionTables() (string, []string) {
+	dirs := tcLibDirs
+	if libDir := os.Getenv("TC_LIB_DIR"); libDir != "" {
+		dirs = []string{libDir}
+	}
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		var tables []string
+		for _, file := range files {
+			if name := file.Name(); strings.HasSuffix(name, ".dist") {
+				tables = append(tables, strings.TrimSuffix(name, ".dist"))
+			}
+		}
+		return dir, tables
+	}
+	return strings.Join(dirs, ", "), nil
+}
+
+func containsString(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func checkNetemPercent(name string, value string) error {
+	match := netemPercentRegexp.FindStringSubmatch(value)
+	if match == nil {
+		return fmt.Errorf("invalid %s: %s", name, value)
+	}
+	if percent, err := strconv.ParseFloat(match[1], 64); err != nil || percent > 100 {
+		return fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return nil
+}
+
+// validateNetemParams 校验可选的netem参数是否适用于故障模式，以及已安装的tc是否支持。
+func (b *baseInfo) validateNetemParams() error {
+	var params []string
+	for _, name := range optionalNetemParams {
+		if _, ok := b.flags[name]; !ok {
+			continue
+		}
+		if !containsString(b.faultType, netemParamFaults[name]) {
+			return fmt.Errorf("param %s not supported by network-%s", name, b.faultType)
+		}
+		params = append(params, name)
+	}
+	if err := b.validateLossModel(); err != nil {
+		return err
+	}
+	if err := b.validateRate(); err != nil {
+		return err
+	}
+	// netem rate需要较新的tc与内核。
+	if b.faultType == "rate" && b.flags["shaper"] != shaperTbf {
+		params = append(params, "rate")
+	}
+	if len(params) == 0 {
+		return nil
+	}
+
+	_, hasJitter := b.flags["jitter"]
+	isDelay := b.faultType == "delay" || b.faultType == "reorder"
+	for _, name := range params {
+		value := b.flags[name]
+		switch name {
+		case "correlation":
+			// delay的相关系数跟在jitter之后。
+			if isDelay && !hasJitter {
+				return fmt.Errorf("param correlation requires jitter")
+			}
+			if err := checkNetemPercent(name, value); err != nil {
+				return err
+			}
+		case "distribution":
+			if !hasJitter {
+				return fmt.Errorf("param distribution requires jitter")
+			}
+		case "gap":
+			if gap, err := strconv.Atoi(value); err != nil || gap <= 0 {
+				return fmt.Errorf("invalid gap: %s", value)
+			}
+		}
+	}
+
+	usage, err := netemUsage()
+	if err != nil {
+		return err
+	}
+	for _, name := range params {
+		if keyword, ok := netemParamKeywords[name]; ok && !strings.Contains(usage, keyword) {
+			return fmt.Errorf("installed tc does not support netem param %s", name)
+		}
+	}
+	if distribution, ok := b.flags["distribution"]; ok {
+		dir, tables := distributionTables()
+		if !containsString(distribution, tables) {
+			return fmt.Errorf("distribution table %s not found in %s, available: [%s]",
+				distribution, dir, strings.Join(tables, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"arsenal-hardware/submodules"
+	"arsenal-hardware/util"
+)
+
+// profilesFileName 自定义网络状况配置文件名，位于arsenal/conf/目录。
+const profilesFileName = "network-profiles.json"
+
+// networkProfile 网络状况配置，各项参数可以为空，表示没有对应的损伤。
+type networkProfile struct {
+	Description string `json:"description,omitempty"`
+	Delay       string `json:"delay,omitempty"`
+	Jitter      string `json:"jitter,omitempty"`
+	Loss        string `json:"loss,omitempty"`
+	Rate        string `json:"rate,omitempty"`
+}
+
+// builtinProfiles 内置的网络状况配置，时延为单向时延。
+var builtinProfiles = map[string]networkProfile{
+	"2g": {Description: "2G/EDGE mobile network", Delay: "300ms", Jitter: "100ms", Loss: "2%", Rate: "200kbit"},
+	"3g": {Description: "3G mobile network", Delay: "100ms", Jitter: "30ms", Loss: "1%", Rate: "1600kbit"},
+	"4g": {Description: "4G/LTE mobile network", Delay: "50ms", Jitter: "10ms", Loss: "0.5%", Rate: "12mbit"},
+	"satellite": {Description: "geostationary satellite link", Delay: "550ms", Jitter: "30ms", Loss: "1%",
+		Rate: "5mbit"},
+	"lossy-wifi":       {Description: "congested Wi-Fi", Delay: "5ms", Jitter: "20ms", Loss: "5%", Rate: "20mbit"},
+	"cross-region-wan": {Description: "cross-region WAN link", Delay: "80ms", Jitter: "5ms", Loss: "0.1%", Rate: "100mbit"},
+}
+
+func init() {
+	var newFaultType = profile{
+		FaultType: "network-profile",
+	}
+	submodules.Add(newFaultType.FaultType, &newFaultType)
+}
+
+// profile 按名称注入预设的网络状况：--name satellite，自定义配置读取arsenal/conf/network-profiles.json
+// 或--profiles指定的文件，如：{"office-vpn": {"delay": "40ms", "jitter": "5ms", "loss": "0.2%", "rate": "50mbit"}}，
+// 与内置配置同名时覆盖内置配置。
+type profile struct {
+	FaultType string
+	base      baseInfo
+}
+
+func (p *profile) Prepare(inputArgs []string) error {
+	return p.base.Init(inputArgs)
+}
+
+func (p *profile) FaultInject(_ []string) error {
+	return p.base.Executor()
+}
+
+func (p *profile) FaultRemove(_ []string) error {
+	return p.base.Executor()
+}
+
+func (p *profile) FaultVerify(_ []string) (string, error) {
+	return p.base.Verify()
+}
+
+// values 获取配置中的网络状况参数。
+func (p networkProfile) values() map[string]string {
+	values := make(map[string]string)
+	for name, value := range map[string]string{"delay": p.Delay, "jitter": p.Jitter, "loss": p.Loss, "rate": p.Rate} {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// loadProfiles 获取内置与自定义的网络状况配置，未指定--profiles且默认配置文件不存在时只有内置配置。
+func loadProfiles(profilesPath string) (map[string]networkProfile, error) {
+	profiles := make(map[string]networkProfile)
+	for name, p := range builtinProfiles {
+		profiles[name] = p
+	}
+	if profilesPath == "" {
+		confDir, err := util.GetArsenalConfDir()
+		if err != nil {
+			return nil, fmt.Errorf("get arsenal conf dir failed(%v)", err)
+		}
+		if profilesPath = filepath.Join(confDir, profilesFileName); !util.FileIsExist(profilesPath) {
+			return profiles, nil
+		}
+	}
+
+	content, err := ioutil.ReadFile(profilesPath)
+	if err != nil {
+		return nil, fmt.Errorf("read profiles file(%s) failed(%v)", profilesPath, err)
+	}
+	custom := make(map[string]networkProfile)
+	if err := json.Unmarshal(content, &custom); err != nil {
+		return nil, fmt.Errorf("parse profiles file(%s) failed(%v)", profilesPath, err)
+	}
+	for name, p := range custom {
+		values := p.values()
+		if len(values) == 0 {
+			return nil, fmt.Errorf("profile %s in %s has no network condition", name, profilesPath)
+		}
+		for _, column := range traceColumns[1:] {
+			if value, ok := values[column]; ok {
+				if err := checkTraceValue(column, value); err != nil {
+					return nil, fmt.Errorf("profile %s in %s: %v", name, profilesPath, err)
+				}
+			}
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// loadProfileFlags 读取--name指定的网络状况配置，作为注入时的netem参数。
+func (b *baseInfo) loadProfileFlags() error {
+	name, ok := b.flags["name"]
+	if !ok {
+		return fmt.Errorf("missing param: name")
+	}
+	profiles, err := loadProfiles(b.flags["profiles"])
+	if err != nil {
+		return err
+	}
+	p, ok := profiles[name]
+	if !ok {
+		var names []string
+		for profileName := range profiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unsupported profile: %s, support: %s", name, strings.Join(names, ", "))
+	}
+	b.applySample(traceSample{Values: p.values()})
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]networkProfile
+		wantErr string
+	}{
+		{
+			name:    "custom profile",
+			content: `{"office-vpn": {"delay": "40ms", "jitter": "5ms", "loss": "0.2%", "rate": "50mbit"}}`,
+			want: map[string]networkProfile{
+				"office-vpn": {Delay: "40ms", Jitter: "5ms", Loss: "0.2%", Rate: "50mbit"},
+				"4g":         builtinProfiles["4g"],
+			},
+		},
+		{
+			name:    "override builtin",
+			content: `{"4g": {"description": "degraded 4G", "delay": "120ms"}}`,
+			want: map[string]networkProfile{
+				"4g": {Description: "degraded 4G", Delay: "120ms"},
+				"3g": builtinProfiles["3g"],
+			},
+		},
+		{name: "invalid json", content: `{"office-vpn": `, wantErr: "parse profiles file"},
+		{name: "empty profile", content: `{"idle": {"description": "nothing"}}`, wantErr: "profile idle"},
+		{name: "invalid delay", content: `{"slow": {"delay": "soon"}}`, wantErr: "profile slow in"},
+		{name: "invalid loss", content: `{"lossy": {"loss": "120%"}}`, wantErr: "invalid loss: 120%"},
+		{name: "invalid rate", content: `{"narrow": {"rate": "fast"}}`, wantErr: "invalid rate: fast"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), profilesFileName)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0640); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadProfiles(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadProfiles() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadProfiles() error = %v", err)
+			}
+			for name, want := range tt.want {
+				if !reflect.DeepEqual(got[name], want) {
+					t.Errorf("profile %s = %+v, want %+v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadProfilesMissingFile(t *testing.T) {
+	if _, err := loadProfiles(filepath.Join(t.TempDir(), profilesFileName)); err == nil ||
+		!strings.Contains(err.Error(), "read profiles file") {
+		t.Errorf("loadProfiles() error = %v, want read profiles file failed", err)
+	}
+}
+
+func TestLoadProfileFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), profilesFileName)
+	if err := ioutil.WriteFile(path, []byte(`{"office-vpn": {"delay": "40ms", "loss": "0.2%"}}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:  "builtin",
+			flags: map[string]string{"interface": "eth0", "name": "satellite", "profiles": path},
+			want: map[string]string{"interface": "eth0", "name": "satellite", "profiles": path,
+				"delay": "550ms", "jitter": "30ms", "loss": "1%", "rate": "5mbit"},
+		},
+		{
+			name:  "custom replaces condition flags",
+			flags: map[string]string{"interface": "eth0", "name": "office-vpn", "profiles": path, "rate": "1mbit"},
+			want: map[string]string{"interface": "eth0", "name": "office-vpn", "profiles": path,
+				"delay": "40ms", "loss": "0.2%"},
+		},
+		{name: "missing name", flags: map[string]string{"interface": "eth0"}, wantErr: "missing param: name"},
+		{
+			name:    "unknown name",
+			flags:   map[string]string{"name": "5g", "profiles": path},
+			wantErr: "unsupported profile: 5g, support: 2g, 3g, 4g, cross-region-wan, lossy-wifi, office-vpn, satellite",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &baseInfo{faultType: "profile", flags: tt.flags}
+			err := b.loadProfileFlags()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadProfileFlags() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadProfileFlags() error = %v", err)
+			}
+			if !reflect.DeepEqual(b.flags, tt.want) {
+				t.Errorf("loadProfileFlags() flags = %v, want %v", b.flags, tt.want)
+			}
+		})
+	}
+}
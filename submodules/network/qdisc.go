@@ -0,0 +1,374 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"arsenal-hardware/util"
+)
+
+// defaultHandle 内核默认创建的qdisc的handle。
+const defaultHandle = "0:"
+
+// firstFaultHandle 故障qdisc从该major开始分配handle，跳过已被使用的major。
+const firstFaultHandle = 0xa0
+
+// packetCountRegexp 匹配tc输出中以p结尾的报文数，如：limit 1000p。
+var packetCountRegexp = regexp.MustCompile(`^\d+p$`)
+
+// qdisc tc qdisc show输出中的一个qdisc。
+type qdisc struct {
+	Kind   string `json:"kind"`
+	Handle string `json:"handle"`
+	// Parent 取值为root或父class的id，如：1:10。
+	Parent string `json:"parent"`
+	// Params 可用于重新创建该qdisc的参数。
+	Params string `json:"params"`
+}
+
+// tcClass tc class show输出中的一个class。
+type tcClass struct {
+	ClassID string `json:"class-id"`
+	Parent  string `json:"parent"`
+}
+
+// qdiscTree 网卡上的qdisc与class。
+type qdiscTree struct {
+	Qdiscs  []qdisc
+	Classes []tcClass
+}
+
+// attachPoint 故障qdisc的挂载位置。
+type attachPoint struct {
+	// Parent 取值为root或class的id。
+	Parent string `json:"parent"`
+	// Original 该位置原有的qdisc，为空表示原来没有qdisc或使用内核默认的qdisc。
+	Original *qdisc `json:"original,omitempty"`
+}
+
+// tcState 网卡的netem故障状态，包括注入第一个故障前网卡原有的qdisc与当前处于注入状态的故障，
+// 保存在arsenal/logs/tc/$interface.json文件，清理最后一个故障时据此恢复原有的qdisc。
+type tcState struct {
+	Interface string `json:"interface"`
+	Root      qdisc  `json:"root"`
+	// Mq 原root为内核默认的mq时，注入时新建的mq的handle。
+	Mq string `json:"mq,omitempty"`
+	// Used 原有qdisc已使用的handle major，故障qdisc不使用这些handle。
+	Used   []string      `json:"used"`
+	Points []attachPoint `json:"points"`
+	Faults []*netemFault `json:"faults"`
+}
+
+func major(id string) string {
+	return strings.SplitN(id, ":", 2)[0]
+}
+
+// normalizeQdiscParams 去掉tc输出中无法作为参数的内容，如：refcnt、以p结尾的报文数。
+func normalizeQdiscParams(kind string, fields []string) string {
+	// pfifo_fast不支持参数。
+	if kind == "pfifo_fast" {
+		return ""
+	}
+	var params []string
+	for i := 0; i < len(fields); i++ {
+		if fields[i] == "refcnt" {
+			i++
+			continue
+		}
+		if packetCountRegexp.MatchString(fields[i]) {
+			params = append(params, strings.TrimSuffix(fields[i], "p"))
+			continue
+		}
+		params = append(params, fields[i])
+	}
+	return strings.Join(params, " ")
+}
+
+// parseQdiscs 解析tc qdisc show输出，忽略ingress与clsact。
+// qdisc tbf 10: parent 1:10 rate 10Mbit burst 32Kb lat 50ms
+func parseQdiscs(output string) []qdisc {
+	const minFields = 4
+	var qdiscs []qdisc
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < minFields || fields[0] != "qdisc" || fields[1] == "ingress" || fields[1] == "clsact" {
+			continue
+		}
+		q := qdisc{Kind: fields[1], Handle: fields[2]}
+		rest := fields[4:]
+		if fields[3] == "root" {
+			q.Parent = "root"
+		} else if fields[3] == "parent" && len(fields) > minFields {
+			q.Parent = fields[4]
+			rest = fields[5:]
+		}
+		q.Params = normalizeQdiscParams(q.Kind, rest)
+		qdiscs = append(qdiscs, q)
+	}
+	return qdiscs
+}
+
+// parseClasses 解析tc class show输出。
+// class htb 1:10 parent 1:1 leaf 10: prio 0 rate 50Mbit ceil 100Mbit burst 1600b cburst 1600b
+func parseClasses(output string) []tcClass {
+	const minFields = 4
+	var classes []tcClass
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < minFields || fields[0] != "class" {
+			continue
+		}
+		c := tcClass{ClassID: fields[2], Parent: "root"}
+		if fields[3] == "parent" && len(fields) > minFields {
+			c.Parent = fields[4]
+		}
+		classes = append(classes, c)
+	}
+	return classes
+}
+
+// captureQdiscTree 读取网卡当前的qdisc与class。
+func captureQdiscTree(nicDevice string) (*qdiscTree, error) {
+	tree := &qdiscTree{}
+	for _, object := range []string{"qdisc", "class"} {
+		shellCmd := fmt.Sprintf("tc %s show dev %s", object, nicDevice)
+		result, err := util.ExecCommandBlock(shellCmd)
+		if err != nil {
+			return nil, fmt.Errorf("execute: %s failed: %v, result: %s", shellCmd, err, result)
+		}
+		if object == "qdisc" {
+			tree.Qdiscs = parseQdiscs(result)
+		} else {
+			tree.Classes = parseClasses(result)
+		}
+	}
+	return tree, nil
+}
+
+func (t *qdiscTree) root() *qdisc {
+	for i := range t.Qdiscs {
+		if t.Qdiscs[i].Parent == "root" {
+			return &t.Qdiscs[i]
+		}
+	}
+	return nil
+}
+
+// child 获取挂在class下的qdisc。
+func (t *qdiscTree) child(classID string) *qdisc {
+	for i := range t.Qdiscs {
+		if t.Qdiscs[i].Parent == classID {
+			return &t.Qdiscs[i]
+		}
+	}
+	return nil
+}
+
+// hasChildren 判断qdisc下是否还显式挂载了其他qdisc。
+func (t *qdiscTree) hasChildren(q *qdisc) bool {
+	for _, other := range t.Qdiscs {
+		if other.Parent != "root" && major(other.Parent) == major(q.Handle) {
+			return true
+		}
+	}
+	return false
+}
+
+// leafClasses 获取root qdisc下没有子class的class。
+func (t *qdiscTree) leafClasses(rootMajor string) []string {
+	var leaves []string
+	for _, c := range t.Classes {
+		if major(c.ClassID) != rootMajor {
+			continue
+		}
+		isLeaf := true
+		for _, other := range t.Classes {
+			if other.Parent == c.ClassID {
+				isLeaf = false
+				break
+			}
+		}
+		if isLeaf {
+			leaves = append(leaves, c.ClassID)
+		}
+	}
+	return leaves
+}
+
+// usedHandles 获取已被使用的handle major。
+func (t *qdiscTree) usedHandles() []string {
+	var used []string
+	for _, q := range t.Qdiscs {
+		used = append(used, major(q.Handle))
+	}
+	return used
+}
+
+// allocHandles 分配count个不在used中的handle。
+func allocHandles(used []string, count int) []string {
+	usedMap := make(map[string]bool)
+	for _, name := range used {
+		usedMap[name] = true
+	}
+	var handles []string
+	for value := firstFaultHandle; len(handles) < count; value++ {
+		if name := strconv.FormatInt(int64(value), 16); !usedMap[name] {
+			handles = append(handles, fmt.Sprintf("%s:", name))
+		}
+	}
+	return handles
+}
+
+// newState 根据网卡当前的qdisc确定故障qdisc的挂载位置，尽量不破坏已有的qdisc：
+// 内核默认的root直接替换，清理时删除root即可恢复；内核默认的mq替换为新建的mq，故障挂在每个队列上；
+// 自定义的有类qdisc将故障挂在每个叶子class上；自定义的无类qdisc直接替换，清理时按原参数重建。
+func (t *qdiscTree) newState(nicDevice string) (*tcState, error) {
+	root := t.root()
+	// tc不显示未启用网卡上的noop qdisc。
+	if root == nil {
+		root = &qdisc{Kind: "noop", Handle: defaultHandle, Parent: "root"}
+	}
+	state := &tcState{Interface: nicDevice, Root: *root, Used: t.usedHandles()}
+
+	switch {
+	case root.Kind == "mq" && root.Handle == defaultHandle:
+		// 内核默认的mq下每个队列对应一个class，如：:1。
+		state.Mq = allocHandles(state.Used, 1)[0]
+		state.Used = append(state.Used, major(state.Mq))
+		for index := range t.Classes {
+			state.Points = append(state.Points, attachPoint{Parent: fmt.Sprintf("%s%x", state.Mq, index+1)})
+		}
+	case root.Handle == defaultHandle:
+		state.Points = []attachPoint{{Parent: "root"}}
+	case len(t.leafClasses(major(root.Handle))) != 0:
+		for _, classID := range t.leafClasses(major(root.Handle)) {
+			child := t.child(classID)
+			if child != nil && t.hasChildren(child) {
+				return nil, fmt.Errorf("qdisc %s %s on %s has nested qdiscs, cannot attach fault without destroying it",
+					child.Kind, child.Handle, nicDevice)
+			}
+			state.Points = append(state.Points, attachPoint{Parent: classID, Original: child})
+		}
+	default:
+		if t.hasChildren(root) {
+			return nil, fmt.Errorf("root qdisc %s %s on %s has nested qdiscs, cannot attach fault without destroying it",
+				root.Kind, root.Handle, nicDevice)
+		}
+		state.Points = []attachPoint{{Parent: "root", Original: root}}
+	}
+	return state, nil
+}
+
+// location 生成tc命令中qdisc的位置参数，如：root、parent 1:10。
+func location(parent string) string {
+	if parent == "root" {
+		return "root"
+	}
+	return fmt.Sprintf("parent %s", parent)
+}
+
+// restoreCommands 生成恢复注入前qdisc的tc命令。
+func (s *tcState) restoreCommands() []string {
+	// 删除root后内核会重新创建默认的qdisc。
+	if s.Root.Handle == defaultHandle {
+		return []string{fmt.Sprintf("tc qdisc del dev %s root", s.Interface)}
+	}
+
+	var shellCommands []string
+	for _, point := range s.Points {
+		original := point.Original
+		if original == nil {
+			shellCommands = append(shellCommands, fmt.Sprintf("tc qdisc del dev %s %s",
+				s.Interface, location(point.Parent)))
+			continue
+		}
+		cmd := fmt.Sprintf("tc qdisc replace dev %s %s", s.Interface, location(point.Parent))
+		if original.Handle != defaultHandle {
+			cmd = fmt.Sprintf("%s handle %s", cmd, original.Handle)
+		}
+		cmd = strings.TrimSpace(fmt.Sprintf("%s %s %s", cmd, original.Kind, original.Params))
+		shellCommands = append(shellCommands, cmd)
+	}
+	return shellCommands
+}
+
+func getTcStatePath(nicDevice string) (string, error) {
+	arsenalLogDir, err := util.GetArsenalLogsDir()
+	if err != nil {
+		return "", fmt.Errorf("get arsenal logs dir failed(%v)", err)
+	}
+	tcDir := filepath.Join(arsenalLogDir, "tc")
+	if err := os.MkdirAll(tcDir, 0750); err != nil {
+		return "", fmt.Errorf("create tc state dir(%s) failed(%v)", tcDir, err)
+	}
+	return filepath.Join(tcDir, fmt.Sprintf("%s.json", nicDevice)), nil
+}
+
+// loadTcState 读取网卡的netem故障状态，不存在时返回nil。
+func loadTcState(nicDevice string) (*tcState, error) {
+	statePath, err := getTcStatePath(nicDevice)
+	if err != nil {
+		return nil, err
+	}
+	if !util.FileIsExist(statePath) {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("read tc state(%s) failed(%v)", statePath, err)
+	}
+	state := &tcState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("parse tc state(%s) failed(%v)", statePath, err)
+	}
+	return state, nil
+}
+
+func saveTcState(state *tcState) error {
+	statePath, err := getTcStatePath(state.Interface)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal tc state failed(%v)", err)
+	}
+	tmpPath := fmt.Sprintf("%s.tmp", statePath)
+	if err := ioutil.WriteFile(tmpPath, content, 0640); err != nil {
+		return fmt.Errorf("write tc state(%s) failed(%v)", tmpPath, err)
+	}
+	return os.Rename(tmpPath, statePath)
+}
+
+func removeTcState(nicDevice string) error {
+	statePath, err := getTcStatePath(nicDevice)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove tc state(%s) failed(%v)", statePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 以下为不同配置的网卡上tc qdisc show与tc class show的输出。
+const (
+	mqQdiscOutput = `qdisc mq 0: root
+qdisc fq_codel 0: parent :2 limit 10240p flows 1024 quantum 1514 target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64
+qdisc fq_codel 0: parent :1 limit 10240p flows 1024 quantum 1514 target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64
+`
+	mqClassOutput = `class mq :1 root
+class mq :2 root
+`
+	defaultFqCodelQdiscOutput = `qdisc fq_codel 0: root refcnt 2 limit 10240p flows 1024 quantum 1514 target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64
+`
+	fqCodelQdiscOutput = `qdisc fq_codel 8001: root refcnt 2 limit 2048p flows 1024 quantum 1514 target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64
+qdisc ingress ffff: parent ffff:fff1 ----------------
+`
+	htbQdiscOutput = `qdisc htb 1: root refcnt 2 r2q 10 default 0x20 direct_packets_stat 0 direct_qlen 1000
+qdisc sfq 10: parent 1:10 limit 127p quantum 1514b depth 127 divisor 1024 perturb 10sec
+`
+	htbClassOutput = `class htb 1:1 root rate 100Mbit ceil 100Mbit burst 1600b cburst 1600b
+class htb 1:10 parent 1:1 leaf 10: prio 0 rate 50Mbit ceil 100Mbit burst 1600b cburst 1600b
+class htb 1:20 parent 1:1 prio 0 rate 50Mbit ceil 100Mbit burst 1600b cburst 1600b
+`
+	tbfNestedQdiscOutput = `qdisc tbf 1: root refcnt 2 rate 100Mbit burst 32Kb lat 50ms
+qdisc pfifo 10: parent 1:1 limit 100p
+`
+	tbfNestedClassOutput = `class tbf 1:1 parent 1: leaf 10:
+`
+	htbNestedQdiscOutput = `qdisc htb 1: root refcnt 2 r2q 10 default 0x10 direct_packets_stat 0 direct_qlen 1000
+qdisc prio 10: parent 1:10 bands 3 priomap 1 2 2 2 1 2 0 0 1 1 1 1 1 1 1 1
+qdisc sfq 20: parent 10:1 limit 127p quantum 1514b depth 127 divisor 1024
+`
+	htbNestedClassOutput = `class htb 1:10 root leaf 10: prio 0 rate 100Mbit ceil 100Mbit burst 1600b cburst 1600b
+class prio 10:1 parent 10: leaf 20:
+class prio 10:2 parent 10:
+class prio 10:3 parent 10:
+`
+	rootPrioQdiscOutput = `qdisc prio 1: root refcnt 2 bands 3 priomap 1 2 2 2 1 2 0 0 1 1 1 1 1 1 1 1
+qdisc sfq 10: parent 1:1 limit 127p quantum 1514b depth 127 divisor 1024
+`
+)
+
+func TestNormalizeQdiscParams(t *testing.T) {
+	tests := []struct {
+		kind   string
+		fields string
+		want   string
+	}{
+		{kind: "fq_codel", fields: "refcnt 2 limit 10240p flows 1024", want: "limit 10240 flows 1024"},
+		{kind: "pfifo_fast", fields: "refcnt 2 bands 3 priomap 1 2 2 2 1 2 0 0 1 1 1 1 1 1 1 1", want: ""},
+		{kind: "tbf", fields: "rate 100Mbit burst 32Kb lat 50ms", want: "rate 100Mbit burst 32Kb lat 50ms"},
+		{kind: "noqueue", fields: "refcnt 2", want: ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeQdiscParams(tt.kind, strings.Fields(tt.fields)); got != tt.want {
+			t.Errorf("normalizeQdiscParams(%s, %q) = %q, want %q", tt.kind, tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestParseQdiscs(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []qdisc
+	}{
+		{
+			name:   "default mq",
+			output: mqQdiscOutput,
+			want: []qdisc{
+				{Kind: "mq", Handle: "0:", Parent: "root"},
+				{Kind: "fq_codel", Handle: "0:", Parent: ":2", Params: "limit 10240 flows 1024 quantum 1514 " +
+					"target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64"},
+				{Kind: "fq_codel", Handle: "0:", Parent: ":1", Params: "limit 10240 flows 1024 quantum 1514 " +
+					"target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64"},
+			},
+		},
+		{
+			name:   "fq_codel with ingress",
+			output: fqCodelQdiscOutput,
+			want: []qdisc{
+				{Kind: "fq_codel", Handle: "8001:", Parent: "root", Params: "limit 2048 flows 1024 quantum 1514 " +
+					"target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64"},
+			},
+		},
+		{
+			name:   "htb with leaves",
+			output: htbQdiscOutput,
+			want: []qdisc{
+				{Kind: "htb", Handle: "1:", Parent: "root", Params: "r2q 10 default 0x20 direct_packets_stat 0 direct_qlen 1000"},
+				{Kind: "sfq", Handle: "10:", Parent: "1:10", Params: "limit 127 quantum 1514b depth 127 divisor 1024 perturb 10sec"},
+			},
+		},
+		{
+			name:   "empty",
+			output: "",
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseQdiscs(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQdiscs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseClasses(t *testing.T) {
+	want := []tcClass{
+		{ClassID: "1:1", Parent: "root"},
+		{ClassID: "1:10", Parent: "1:1"},
+		{ClassID: "1:20", Parent: "1:1"},
+	}
+	if got := parseClasses(htbClassOutput); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseClasses() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAllocHandles(t *testing.T) {
+	tests := []struct {
+		used  []string
+		count int
+		want  []string
+	}{
+		{used: nil, count: 3, want: []string{"a0:", "a1:", "a2:"}},
+		{used: []string{"0", "1", "10"}, count: 2, want: []string{"a0:", "a1:"}},
+		{used: []string{"a0", "a2"}, count: 3, want: []string{"a1:", "a3:", "a4:"}},
+	}
+	for _, tt := range tests {
+		if got := allocHandles(tt.used, tt.count); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("allocHandles(%v, %d) = %v, want %v", tt.used, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestNewState(t *testing.T) {
+	sfq := &qdisc{Kind: "sfq", Handle: "10:", Parent: "1:10",
+		Params: "limit 127 quantum 1514b depth 127 divisor 1024 perturb 10sec"}
+	tests := []struct {
+		name        string
+		qdiscOutput string
+		classOutput string
+		wantMq      string
+		wantUsed    []string
+		wantPoints  []attachPoint
+		wantRestore []string
+		wantErr     string
+	}{
+		{
+			name:        "default mq",
+			qdiscOutput: mqQdiscOutput,
+			classOutput: mqClassOutput,
+			wantMq:      "a0:",
+			wantUsed:    []string{"0", "0", "0", "a0"},
+			wantPoints:  []attachPoint{{Parent: "a0:1"}, {Parent: "a0:2"}},
+			wantRestore: []string{"tc qdisc del dev eth0 root"},
+		},
+		{
+			name:        "default fq_codel",
+			qdiscOutput: defaultFqCodelQdiscOutput,
+			wantUsed:    []string{"0"},
+			wantPoints:  []attachPoint{{Parent: "root"}},
+			wantRestore: []string{"tc qdisc del dev eth0 root"},
+		},
+		{
+			name:        "down interface",
+			qdiscOutput: "",
+			wantPoints:  []attachPoint{{Parent: "root"}},
+			wantRestore: []string{"tc qdisc del dev eth0 root"},
+		},
+		{
+			name:        "fq_codel",
+			qdiscOutput: fqCodelQdiscOutput,
+			wantUsed:    []string{"8001"},
+			wantPoints: []attachPoint{{Parent: "root", Original: &qdisc{Kind: "fq_codel", Handle: "8001:", Parent: "root",
+				Params: "limit 2048 flows 1024 quantum 1514 target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64"}}},
+			wantRestore: []string{"tc qdisc replace dev eth0 root handle 8001: fq_codel limit 2048 flows 1024 " +
+				"quantum 1514 target 5ms interval 100ms memory_limit 32Mb ecn drop_batch 64"},
+		},
+		{
+			name:        "htb with leaves",
+			qdiscOutput: htbQdiscOutput,
+			classOutput: htbClassOutput,
+			wantUsed:    []string{"1", "10"},
+			wantPoints:  []attachPoint{{Parent: "1:10", Original: sfq}, {Parent: "1:20"}},
+			wantRestore: []string{
+				"tc qdisc replace dev eth0 parent 1:10 handle 10: sfq limit 127 quantum 1514b depth 127 divisor 1024 perturb 10sec",
+				"tc qdisc del dev eth0 parent 1:20",
+			},
+		},
+		{
+			name:        "tbf with child qdisc",
+			qdiscOutput: tbfNestedQdiscOutput,
+			classOutput: tbfNestedClassOutput,
+			wantUsed:    []string{"1", "10"},
+			wantPoints: []attachPoint{{Parent: "1:1",
+				Original: &qdisc{Kind: "pfifo", Handle: "10:", Parent: "1:1", Params: "limit 100"}}},
+			wantRestore: []string{"tc qdisc replace dev eth0 parent 1:1 handle 10: pfifo limit 100"},
+		},
+		{
+			name:        "nested qdisc under htb leaf",
+			qdiscOutput: htbNestedQdiscOutput,
+			classOutput: htbNestedClassOutput,
+			wantErr:     "qdisc prio 10: on eth0 has nested qdiscs",
+		},
+		{
+			name:        "nested qdisc under classless root",
+			qdiscOutput: rootPrioQdiscOutput,
+			wantErr:     "root qdisc prio 1: on eth0 has nested qdiscs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := &qdiscTree{Qdiscs: parseQdiscs(tt.qdiscOutput), Classes: parseClasses(tt.classOutput)}
+			state, err := tree.newState("eth0")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newState() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newState() error = %v", err)
+			}
+			if state.Mq != tt.wantMq {
+				t.Errorf("Mq = %q, want %q", state.Mq, tt.wantMq)
+			}
+			if !reflect.DeepEqual(state.Used, tt.wantUsed) {
+				t.Errorf("Used = %v, want %v", state.Used, tt.wantUsed)
+			}
+			if !reflect.DeepEqual(state.Points, tt.wantPoints) {
+				t.Errorf("Points = %+v, want %+v", state.Points, tt.wantPoints)
+			}
+			if got := state.restoreCommands(); !reflect.DeepEqual(got, tt.wantRestore) {
+				t.Errorf("restoreCommands() = %q, want %q", got, tt.wantRestore)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rampLinear 按固定间隔线性调整参数。
+	rampLinear = "linear"
+	// rampStep 将参数变化平均分成若干阶跃。
+	rampStep = "step"
+
+	defaultRampInterval = time.Second
+	defaultRampSteps    = 10
+)
+
+// rampValueRegexp 匹配带单位的数值参数，如：10ms、1.5%。
+var rampValueRegexp = regexp.MustCompile(`^([0-9]*\.?[0-9]+)([a-z%]*)$`)
+
+// ramp tc参数渐变：--ramp-to 30% --ramp-duration 10m --ramp-schedule linear|step
+// --ramp-interval 1s --ramp-steps 10，参数从故障原有取值开始变化。
+type ramp struct {
+	param    string
+	from     float64
+	to       float64
+	unit     string
+	duration time.Duration
+	schedule string
+	interval time.Duration
+	steps    int
+}
+
+// rampParam 获取故障模式可渐变的数值参数。
+func (b *baseInfo) rampParam() string {
+	switch b.faultType {
+	case "delay":
+		return "delay"
+	case "rate":
+		return "rate"
+	default:
+		return "percent"
+	}
+}
+
+// splitRampValue 拆分带单位的数值参数，如：10Mbit拆分为10与mbit。
+func splitRampValue(value string) (float64, string, error) {
+	// tc的单位不区分大小写，如：10Mbit。
+	match := rampValueRegexp.FindStringSubmatch(strings.ToLower(value))
+	if match == nil {
+		return 0, "", fmt.Errorf("invalid ramp value: %s", value)
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	return number, match[2], err
+}
+
+// parseRamp 解析参数渐变配置，未指定--ramp-to时返回nil。
+func (b *baseInfo) parseRamp() (*ramp, error) {
+	rampTo, ok := b.flags["ramp-to"]
+	if !ok {
+		return nil, nil
+	}
+
+	var err error
+	r := &ramp{
+		param:    b.rampParam(),
+		schedule: rampLinear,
+		interval: defaultRampInterval,
+		steps:    defaultRampSteps,
+	}
+	fromValue, ok := b.flags[r.param]
+	if !ok {
+		return nil, fmt.Errorf("missing param: %s", r.param)
+	}
+	if r.from, r.unit, err = splitRampValue(fromValue); err != nil {
+		return nil, err
+	}
+	var toUnit string
+	if r.to, toUnit, err = splitRampValue(rampTo); err != nil {
+		return nil, err
+	}
+	if toUnit != "" && toUnit != r.unit {
+		return nil, fmt.Errorf("ramp-to unit %s not match %s unit %s", toUnit, r.param, r.unit)
+	}
+
+	if r.duration, err = time.ParseDuration(b.flags["ramp-duration"]); err != nil || r.duration <= 0 {
+		return nil, fmt.Errorf("invalid ramp-duration: %s", b.flags["ramp-duration"])
+	}
+	if schedule, ok := b.flags["ramp-schedule"]; ok {
+		if schedule != rampLinear && schedule != rampStep {
+			return nil, fmt.Errorf("invalid ramp-schedule: %s, support: linear, step", schedule)
+		}
+		r.schedule = schedule
+	}
+	if interval, ok := b.flags["ramp-interval"]; ok {
+		if r.interval, err = time.ParseDuration(interval); err != nil || r.interval <= 0 {
+			return nil, fmt.Errorf("invalid ramp-interval: %s", interval)
+		}
+	}
+	if steps, ok := b.flags["ramp-steps"]; ok {
+		if r.steps, err = strconv.Atoi(steps); err != nil || r.steps <= 0 {
+			return nil, fmt.Errorf("invalid ramp-steps: %s", steps)
+		}
+	}
+	return r, nil
+}
+
+// tickInterval 获取参数调整的时间间隔。
+func (r *ramp) tickInterval() time.Duration {
+	if r.schedule == rampStep {
+		return r.duration / time.Duration(r.steps)
+	}
+	return r.interval
+}
+
+// valueAt 计算渐变开始elapsed时长后的参数取值。
+func (r *ramp) valueAt(elapsed time.Duration) string {
+	progress := float64(elapsed) / float64(r.duration)
+	if progress > 1 {
+		progress = 1
+	}
+	if r.schedule == rampStep {
+		// 阶跃取值只在每个阶段结束时变化，最后一个阶段结束时达到目标值。
+		progress = float64(int(progress*float64(r.steps)+1e-9)) / float64(r.steps)
+	}
+	// tc参数精度有限，保留三位小数。
+	const precision = 1000
+	value := math.Round((r.from+(r.to-r.from)*progress)*precision) / precision
+	return fmt.Sprintf("%s%s", strconv.FormatFloat(value, 'f', -1, 64), r.unit)
+}
+
+// NeedRamp 判断是否需要启动参数渐变后台进程。
+func (b *baseInfo) NeedRamp() bool {
+	_, ok := b.flags["ramp-to"]
+	return ok
+}
+
+// Ramp 按渐变计划使用tc qdisc change原地修改netem参数，达到目标值或stop被关闭时返回。
+func (b *baseInfo) Ramp(stop <-chan struct{}) error {
+	r, err := b.parseRamp()
+	if err != nil || r == nil {
+		return err
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(r.tickInterval())
+	defer ticker.Stop()
+	lastValue := b.flags[r.param]
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		elapsed := time.Since(start)
+		value := r.valueAt(elapsed)
+		if value != lastValue {
+			b.flags[r.param] = value
+			if err := b.changeNetem(); err != nil {
+				return err
+			}
+			fmt.Printf("%s %s %s\n", time.Now().Format(time.RFC3339), r.param, value)
+			lastValue = value
+		}
+		if elapsed >= r.duration {
+			return nil
+		}
+	}
+}
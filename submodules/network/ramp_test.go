@@ -0,0 +1,112 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		faultType string
+		flags     map[string]string
+		wantFrom  float64
+		wantTo    float64
+		wantUnit  string
+		wantErr   string
+	}{
+		{
+			name:      "delay",
+			faultType: "delay",
+			flags:     map[string]string{"delay": "100ms", "ramp-to": "500ms", "ramp-duration": "1m"},
+			wantFrom:  100, wantTo: 500, wantUnit: "ms",
+		},
+		{
+			name:      "mixed case rate",
+			faultType: "rate",
+			flags:     map[string]string{"rate": "10Mbit", "ramp-to": "20Mbit", "ramp-duration": "1m"},
+			wantFrom:  10, wantTo: 20, wantUnit: "mbit",
+		},
+		{
+			name:      "target without unit",
+			faultType: "loss",
+			flags:     map[string]string{"percent": "10%", "ramp-to": "50", "ramp-duration": "1m"},
+			wantFrom:  10, wantTo: 50, wantUnit: "%",
+		},
+		{
+			name:      "unit mismatch",
+			faultType: "rate",
+			flags:     map[string]string{"rate": "10mbit", "ramp-to": "20kbit", "ramp-duration": "1m"},
+			wantErr:   "ramp-to unit kbit not match rate unit mbit",
+		},
+		{
+			name:      "invalid value",
+			faultType: "delay",
+			flags:     map[string]string{"delay": "100ms", "ramp-to": "fast", "ramp-duration": "1m"},
+			wantErr:   "invalid ramp value: fast",
+		},
+		{
+			name:      "missing duration",
+			faultType: "delay",
+			flags:     map[string]string{"delay": "100ms", "ramp-to": "500ms"},
+			wantErr:   "invalid ramp-duration: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &baseInfo{faultType: tt.faultType, flags: tt.flags}
+			r, err := b.parseRamp()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseRamp() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRamp() error = %v", err)
+			}
+			if r.from != tt.wantFrom || r.to != tt.wantTo || r.unit != tt.wantUnit {
+				t.Errorf("parseRamp() = %v %v %s, want %v %v %s", r.from, r.to, r.unit, tt.wantFrom, tt.wantTo, tt.wantUnit)
+			}
+		})
+	}
+}
+
+func TestRampValueAt(t *testing.T) {
+	linear := &ramp{from: 10, to: 20, unit: "mbit", duration: 10 * time.Second, schedule: rampLinear}
+	step := &ramp{from: 0, to: 30, unit: "%", duration: 30 * time.Second, schedule: rampStep, steps: 3}
+	tests := []struct {
+		r       *ramp
+		elapsed time.Duration
+		want    string
+	}{
+		{r: linear, elapsed: 0, want: "10mbit"},
+		{r: linear, elapsed: 2500 * time.Millisecond, want: "12.5mbit"},
+		{r: linear, elapsed: time.Minute, want: "20mbit"},
+		{r: step, elapsed: 9 * time.Second, want: "0%"},
+		{r: step, elapsed: 10 * time.Second, want: "10%"},
+		{r: step, elapsed: 30 * time.Second, want: "30%"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.valueAt(tt.elapsed); got != tt.want {
+			t.Errorf("%s valueAt(%v) = %s, want %s", tt.r.schedule, tt.elapsed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"arsenal-hardware/submodules"
+)
+
+const (
+	// shaperNetem 使用netem rate限制带宽，可以指定报文开销与信元大小。
+	shaperNetem = "netem"
+	// shaperTbf 在netem下挂载tbf限制带宽，可以指定突发大小与排队时延。
+	shaperTbf = "tbf"
+
+	defaultTbfBurst   = "32kb"
+	defaultTbfLatency = "50ms"
+)
+
+// rateRegexp 匹配tc的速率参数，如：10mbit、512kbps。
+var rateRegexp = regexp.MustCompile(`^[0-9]*\.?[0-9]+([kmgt]i?)?(bit|bps)?$`)
+
+func init() {
+	var newFaultType = rate{
+		FaultType: "network-rate",
+	}
+	submodules.Add(newFaultType.FaultType, &newFaultType)
+}
+
+type rate struct {
+	FaultType string
+	base      baseInfo
+}
+
+func (r *rate) Prepare(inputArgs []string) error {
+	return r.base.Init(inputArgs)
+}
+
+func (r *rate) FaultInject(_ []string) error {
+	return r.base.Executor()
+}
+
+func (r *rate) FaultRemove(_ []string) error {
+	return r.base.Executor()
+}
+
+func (r *rate) FaultVerify(_ []string) (string, error) {
+	return r.base.Verify()
+}
+
+func (r *rate) NeedWorker(_ []string) bool {
+	return r.base.NeedRamp()
+}
+
+func (r *rate) FaultWork(_ []string, stop <-chan struct{}) error {
+	return r.base.Ramp(stop)
+}
+
+// validateRate 校验带宽限制参数。
+func (b *baseInfo) validateRate() error {
+	if b.faultType != "rate" {
+		return nil
+	}
+	rateStr, ok := b.flags["rate"]
+	if !ok {
+		return fmt.Errorf("missing param: rate")
+	}
+	if !rateRegexp.MatchString(strings.ToLower(rateStr)) {
+		return fmt.Errorf("invalid rate: %s", rateStr)
+	}
+
+	shaper := shaperNetem
+	if value, ok := b.flags["shaper"]; ok {
+		if value != shaperNetem && value != shaperTbf {
+			return fmt.Errorf("invalid shaper: %s, support: %s, %s", value, shaperNetem, shaperTbf)
+		}
+		shaper = value
+	}
+	params := map[string][]string{
+		shaperNetem: {"packet-overhead", "cell-size", "cell-overhead"},
+		shaperTbf:   {"burst", "latency"},
+	}
+	for name, names := range params {
+		if name == shaper {
+			continue
+		}
+		for _, param := range names {
+			if _, ok := b.flags[param]; ok {
+				return fmt.Errorf("param %s not supported by shaper %s", param, shaper)
+			}
+		}
+	}
+	// netem rate的可选参数按位置解析，只能省略末尾的若干个。
+	names := params[shaperNetem]
+	for i := 1; i < len(names); i++ {
+		if _, ok := b.flags[names[i]]; ok {
+			if _, ok := b.flags[names[i-1]]; !ok {
+				return fmt.Errorf("param %s requires %s", names[i], names[i-1])
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		wantErr string
+	}{
+		{name: "netem rate", flags: map[string]string{"rate": "10mbit"}},
+		{name: "mixed case", flags: map[string]string{"rate": "512Kbps"}},
+		{name: "binary prefix", flags: map[string]string{"rate": "1.5gibit"}},
+		{name: "overhead and cell size", flags: map[string]string{"rate": "10mbit", "packet-overhead": "20", "cell-size": "1500"}},
+		{
+			name:  "tbf burst and latency",
+			flags: map[string]string{"rate": "10mbit", "shaper": shaperTbf, "burst": "64kb", "latency": "20ms"},
+		},
+		{name: "missing rate", flags: map[string]string{}, wantErr: "missing param: rate"},
+		{name: "invalid rate", flags: map[string]string{"rate": "fast"}, wantErr: "invalid rate: fast"},
+		{name: "negative rate", flags: map[string]string{"rate": "-1mbit"}, wantErr: "invalid rate: -1mbit"},
+		{
+			name:    "invalid shaper",
+			flags:   map[string]string{"rate": "10mbit", "shaper": "htb"},
+			wantErr: "invalid shaper: htb",
+		},
+		{
+			name:    "tbf param with netem shaper",
+			flags:   map[string]string{"rate": "10mbit", "burst": "64kb"},
+			wantErr: "param burst not supported by shaper netem",
+		},
+		{
+			name:    "netem param with tbf shaper",
+			flags:   map[string]string{"rate": "10mbit", "shaper": shaperTbf, "cell-size": "1500"},
+			wantErr: "param cell-size not supported by shaper tbf",
+		},
+		{
+			name:    "cell size without packet overhead",
+			flags:   map[string]string{"rate": "10mbit", "cell-size": "1500"},
+			wantErr: "param cell-size requires packet-overhead",
+		},
+		{
+			name:    "cell overhead without cell size",
+			flags:   map[string]string{"rate": "10mbit", "packet-overhead": "20", "cell-overhead": "4"},
+			wantErr: "param cell-overhead requires cell-size",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &baseInfo{faultType: "rate", flags: tt.flags}
+			err := b.validateRate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateRate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateRate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRateOtherFault(t *testing.T) {
+	b := &baseInfo{faultType: "delay", flags: map[string]string{"delay": "100ms"}}
+	if err := b.validateRate(); err != nil {
+		t.Errorf("validateRate() error = %v", err)
+	}
+}
@@ -43,3 +43,15 @@ func (r *reorder) FaultInject(_ []string) error {
 func (r *reorder) FaultRemove(_ []string) error {
 	return r.base.Executor()
 }
+
+func (r *reorder) FaultVerify(_ []string) (string, error) {
+	return r.base.Verify()
+}
+
+func (r *reorder) NeedWorker(_ []string) bool {
+	return r.base.NeedRamp()
+}
+
+func (r *reorder) FaultWork(_ []string, stop <-chan struct{}) error {
+	return r.base.Ramp(stop)
+}
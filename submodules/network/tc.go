@@ -18,7 +18,6 @@ package network
 
 import (
 	"fmt"
-	"strings"
 	"time"
 
 	"arsenal-hardware/internal/parse"
@@ -38,43 +37,86 @@ type baseInfo struct {
 	flags     map[string]string
 	opsType   string
 	faultType string
-	tcOpsType string
+	// reserved 故障运行过程中会设置的netem参数。
+	reserved []string
 }
 
 func (b *baseInfo) Init(inputArgs []string) error {
-	dependCmd := []string{"tc", "lsmod", "grep", "modprobe"}
+	dependCmd := []string{"tc", "modprobe"}
 	if missingCmd, isMissCmd := util.CheckEnvShellCommand(dependCmd); isMissCmd {
 		return fmt.Errorf("missing command: %s", missingCmd)
 	}
 
-	checkModuleCmd := "lsmod | grep sch_netem"
-	retString, err := util.ExecCommandBlock(checkModuleCmd)
-	// 如果没有抓到对应关键字，返回值为1。
-	if err != nil && retString == " " {
-		return fmt.Errorf("execute: %s failed: %s, result: %s", checkModuleCmd, err, retString)
+	b.flags = parse.TransInputFlagsToMap(inputArgs)
+	b.reserved = nil
+	b.opsType = inputArgs[submodules.OpsTypeIndex]
+	b.faultType = inputArgs[submodules.FaultTypeIndex]
+	if _, err := b.parseRamp(); err != nil {
+		return err
 	}
-
-	if !strings.Contains(retString, "sch_netem") {
-		loadModuleCmd := "modprobe sch_netem"
-		if result, err := util.ExecCommandBlock(loadModuleCmd); err != nil {
-			return fmt.Errorf("execute shell command: %s failed, error: %s, result: %s",
-				loadModuleCmd, err, result)
+	if err := b.validateNetemParams(); err != nil {
+		return err
+	}
+	if err := b.validateDirection(); err != nil {
+		return err
+	}
+	if err := b.validateFilters(); err != nil {
+		return err
+	}
+	// 清理故障时不需要轨迹文件与网络状况配置。
+	if b.faultType == "trace" && b.opsType != submodules.Remove {
+		if _, err := b.loadTraceFlags(); err != nil {
+			return err
+		}
+	}
+	if b.faultType == "profile" && b.opsType != submodules.Remove {
+		if err := b.loadProfileFlags(); err != nil {
+			return err
 		}
 	}
 
-	b.flags = parse.TransInputFlagsToMap(inputArgs)
-	b.opsType = inputArgs[submodules.OpsTypeIndex]
-	b.faultType = inputArgs[submodules.FaultTypeIndex]
-	return nil
+	modules := []string{"sch_netem"}
+	if b.needIngress() {
+		if missingCmd, isMissCmd := util.CheckEnvShellCommand([]string{"ip"}); isMissCmd {
+			return fmt.Errorf("missing command: %s", missingCmd)
+		}
+		modules = append(modules, ingressModules...)
+	}
+	preflight := util.NewPreflight(fmt.Sprintf("network-%s", b.faultType))
+	preflight.RequireCapability(util.CapNetAdmin)
+	for _, module := range modules {
+		// 模块未加载时尝试加载，加载失败由前置检查给出缺失项。
+		if !util.KernelModuleIsLoaded(module) {
+			_, _ = util.ExecCommandBlock(fmt.Sprintf("modprobe %s", module))
+		}
+		preflight.RequireKernelModule(module)
+	}
+	return preflight.Check()
 }
 
-// Executor 执行tc命令。
+// Executor 执行tc命令。同一网卡上的netem故障共同组成网卡的故障qdisc，过滤条件相同的故障合并为一个netem，
+// 每次注入或清理一个故障后按剩余的故障重建，清理最后一个故障时恢复网卡原有的qdisc。
 func (b *baseInfo) Executor() error {
-	shellCommands, err := b.getTcExecuteShellCmd()
+	nicDevice, ok := b.flags["interface"]
+	if !ok {
+		return fmt.Errorf("missing param: interface")
+	}
+	if _, ok := tcOps[b.opsType]; !ok {
+		return fmt.Errorf("not support fault operation: %s", b.opsType)
+	}
+
+	unlock, err := lockInterface(nicDevice)
 	if err != nil {
-		return fmt.Errorf("get tc fault inject shell command failed: %v", err)
+		return err
 	}
+	defer unlock()
+	if b.opsType == submodules.Remove {
+		return b.remove(nicDevice)
+	}
+	return b.inject(nicDevice)
+}
 
+func execTcCommands(shellCommands []string) error {
 	const interval = 100
 	for i := 0; i < len(shellCommands); i++ {
 		if result, err := util.ExecCommandBlock(shellCommands[i]); err != nil {
@@ -85,145 +127,100 @@ func (b *baseInfo) Executor() error {
 	return nil
 }
 
-// shouldAddTcFilter 判断是否需要添加tc filter。
-func (b *baseInfo) shouldAddTcFilter() bool {
-	for i := 0; i < len(filterFlags); i++ {
-		if b.flags[filterFlags[i]] != "" {
-			return true
-		}
+// fault 获取当前故障在网卡netem故障状态中的记录。
+func (b *baseInfo) fault() *netemFault {
+	flags := make(map[string]string, len(b.flags))
+	for name, value := range b.flags {
+		flags[name] = value
 	}
-	return false
+	return &netemFault{FaultType: b.faultType, Flags: flags, Reserved: b.reserved}
 }
 
-func (b *baseInfo) iterFilterCmd() string {
-	var cmd string
-	if nicDevice, ok := b.flags["interface"]; ok {
-		cmd = fmt.Sprintf("tc filter add dev %s protocol ip parent 1:0 prio 4 u32", nicDevice)
+// injectDevice 在网卡上注入当前故障。
+func (b *baseInfo) injectDevice(nicDevice string) error {
+	state, err := loadTcState(nicDevice)
+	if err != nil {
+		return err
 	}
-
-	var matchArg string
-	for i := 0; i < len(filterFlags); i++ {
-		value, ok := b.flags[filterFlags[i]]
-		if !ok {
-			continue
-		}
-		switch filterFlags[i] {
-		case "source":
-			if sourceSubnetMask, ok := b.flags["source-subnet-mask"]; ok {
-				matchArg = fmt.Sprintf("match ip src %s/%s", value, sourceSubnetMask)
-			} else {
-				matchArg = fmt.Sprintf("match ip src %s", value)
-			}
-		case "destination":
-			if destinationSubnetMask, ok := b.flags["destination-subnet-mask"]; ok {
-				matchArg = fmt.Sprintf("match ip dst %s/%s", value, destinationSubnetMask)
-			} else {
-				matchArg = fmt.Sprintf("match ip dst %s", value)
-			}
-		case "source-port":
-			matchArg = fmt.Sprintf("match ip sport %s 0xffff", value)
-		case "destination-port":
-			matchArg = fmt.Sprintf("match ip dport %s 0xffff", value)
-		default:
-			break
+	// 网卡上还没有netem故障时记录原有的qdisc。
+	if state == nil {
+		tree, err := captureQdiscTree(nicDevice)
+		if err != nil {
+			return err
 		}
-		if matchArg != "" {
-			cmd = fmt.Sprintf("%s %s", cmd, matchArg)
-			matchArg = ""
+		if state, err = tree.newState(nicDevice); err != nil {
+			return err
 		}
 	}
-	return fmt.Sprintf("%s flowid 1:4", cmd)
-}
 
-// getTcFilterCommands 如果需要设定tc过滤器，需要返回三条shell命令。
-func (b *baseInfo) getTcFilterCommands() []string {
-	var shellCommands []string
-	switch b.faultType {
-	case "delay":
-		// tc qdisc add dev ens18 root handle 1: prio bands 4
-		nicDevice, ok := b.flags["interface"]
-		if !ok {
-			break
-		}
-		tcOpsType := b.tcOpsType
-		cmd1 := fmt.Sprintf("tc qdisc %s dev %s root handle 1: prio bands 4",
-			tcOpsType, nicDevice)
-		shellCommands = append(shellCommands, cmd1)
-
-		// 如果是清理命令，直接将root qdisc移除即可。
-		if b.opsType == submodules.Remove {
-			break
-		}
-
-		delayTime, ok := b.flags["delay"]
-		if !ok {
-			break
-		}
-		// tc qdisc add dev ens18 parent 1:4 handle 40: netem delay 100ms
-		cmd2 := fmt.Sprintf("tc qdisc %s dev %s parent 1:4 handle 40: netem delay %s",
-			tcOpsType, nicDevice, delayTime)
-		shellCommands = append(shellCommands, cmd2)
-
-		// tc filter add dev ens18 protocol ip parent 1:0 prio 4 u32
-		// match ip dst 10.103.176.207 match ip dport 22 0xffff flowid 1:4
-		cmd3 := b.iterFilterCmd()
-		shellCommands = append(shellCommands, cmd3)
-	default:
-		break
-	}
-	return shellCommands
+	fault := b.fault()
+	if state.find(fault.id()) != nil {
+		return fmt.Errorf("%s already injected on %s", fault.name(), nicDevice)
+	}
+	return state.update(append(state.Faults, fault))
 }
 
-func (b *baseInfo) getTcExecuteShellCmd() ([]string, error) {
-	var shellCommands []string
-	tcOperation, ok := tcOps[b.opsType]
-	if !ok {
-		return nil, fmt.Errorf("not support fault operation: %s", b.opsType)
+// removeDevice 清理网卡上的当前故障。
+func (b *baseInfo) removeDevice(nicDevice string) error {
+	state, err := loadTcState(nicDevice)
+	if err != nil {
+		return err
+	}
+	// 没有故障状态时按故障qdisc挂在root上处理。
+	if state == nil {
+		return execTcCommands([]string{fmt.Sprintf("tc qdisc del dev %s root", nicDevice)})
 	}
-	b.tcOpsType = tcOperation
 
+	fault := b.fault()
+	faults := state.without(fault.id())
+	if len(faults) == len(state.Faults) {
+		return fmt.Errorf("%s not injected on %s", fault.name(), nicDevice)
+	}
+	return state.update(faults)
+}
+
+// changeNetem 使用tc qdisc change原地修改当前故障在各方向上所在netem的参数，不会中断网卡上的流量。
+func (b *baseInfo) changeNetem() error {
 	nicDevice, ok := b.flags["interface"]
 	if !ok {
-		return nil, fmt.Errorf("missing param: interface")
+		return fmt.Errorf("missing param: interface")
 	}
-	switch b.faultType {
-	case "loss", "corrupt", "duplicate":
-		percentStr, ok := b.flags["percent"]
-		if !ok {
-			return nil, fmt.Errorf("missing param: percent")
-		}
-		cmd := fmt.Sprintf("tc qdisc %s dev %s root netem %s %s",
-			b.tcOpsType, nicDevice, b.faultType, percentStr)
-		shellCommands = append(shellCommands, cmd)
-	case "delay":
-		if b.shouldAddTcFilter() {
-			shellCommands = b.getTcFilterCommands()
-		} else {
-			timeStr, ok := b.flags["delay"]
-			if !ok {
-				return nil, fmt.Errorf("missing param: delay")
-			}
-			cmd := fmt.Sprintf("tc qdisc %s dev %s root netem delay %s", b.tcOpsType, nicDevice, timeStr)
-			shellCommands = append(shellCommands, cmd)
-		}
-	case "reorder":
-		timeStr, ok := b.flags["delay"]
-		if !ok {
-			return nil, fmt.Errorf("missing param: delay")
-		}
-		percentStr, ok := b.flags["percent"]
-		if !ok {
-			return nil, fmt.Errorf("missing param: percent")
-		}
-		relatperStr, ok := b.flags["relatper"]
-		if !ok {
-			return nil, fmt.Errorf("missing param: relatper")
+	unlock, err := lockInterface(nicDevice)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, direction := range b.directions() {
+		if err := b.changeDevice(directionDevice(nicDevice, direction)); err != nil {
+			return err
 		}
-		cmd := fmt.Sprintf("tc qdisc %s dev %s root netem delay %s %s %s %s",
-			b.tcOpsType, nicDevice, timeStr, b.faultType, percentStr, relatperStr)
-		shellCommands = append(shellCommands, cmd)
-	default:
-		return shellCommands, fmt.Errorf("unsupported fault type: %s", b.faultType)
 	}
-	return shellCommands, nil
+	return nil
+}
+
+// changeDevice 原地修改网卡上当前故障所在netem的参数。
+func (b *baseInfo) changeDevice(nicDevice string) error {
+	state, err := loadTcState(nicDevice)
+	if err != nil {
+		return err
+	}
+	fault := b.fault()
+	var current *netemFault
+	if state != nil {
+		current = state.find(fault.id())
+	}
+	if current == nil {
+		return fmt.Errorf("%s not injected on %s", fault.name(), nicDevice)
+	}
+
+	current.Flags = fault.Flags
+	shellCommands, err := state.changeCommands(current)
+	if err != nil {
+		return err
+	}
+	if err := saveTcState(state); err != nil {
+		return err
+	}
+	return execTcCommands(shellCommands)
 }
@@ -0,0 +1,296 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"arsenal-hardware/submodules"
+)
+
+const (
+	// traceOnce 按轨迹执行一遍，结束后保持最后一个采样点的网络状况直到清理故障。
+	traceOnce = "once"
+	// traceLoop 循环执行轨迹，最后一个采样点保持一个采样间隔后从头开始。
+	traceLoop = "loop"
+
+	defaultTraceInterval = time.Second
+)
+
+// traceColumns 轨迹文件每行的列，除时间戳外均可以为空，表示该采样点没有对应的损伤。
+var traceColumns = []string{"timestamp", "delay", "jitter", "loss", "rate"}
+
+// neutralValues 表示没有对应损伤的netem参数值。
+var neutralValues = map[string]string{"delay": "0ms", "loss": "0%", "rate": "0bit"}
+
+// traceSample 轨迹中的一个采样点。
+type traceSample struct {
+	// Offset 相对第一个采样点的时间。
+	Offset time.Duration
+	Values map[string]string
+}
+
+func init() {
+	var newFaultType = trace{
+		FaultType: "network-trace",
+	}
+	submodules.Add(newFaultType.FaultType, &newFaultType)
+}
+
+// trace 按轨迹文件回放网络状况：--file trace.csv --mode once|loop，轨迹文件每行为
+// timestamp,delay,jitter,loss,rate，如：0.5,80ms,10ms,1%,5mbit，时间戳为秒或带单位的时长。
+type trace struct {
+	FaultType string
+	base      baseInfo
+}
+
+func (t *trace) Prepare(inputArgs []string) error {
+	return t.base.Init(inputArgs)
+}
+
+func (t *trace) FaultInject(_ []string) error {
+	return t.base.Executor()
+}
+
+func (t *trace) FaultRemove(_ []string) error {
+	return t.base.Executor()
+}
+
+func (t *trace) FaultVerify(_ []string) (string, error) {
+	return t.base.Verify()
+}
+
+func (t *trace) NeedWorker(_ []string) bool {
+	return true
+}
+
+func (t *trace) FaultWork(_ []string, stop <-chan struct{}) error {
+	return t.base.Replay(stop)
+}
+
+// parseTraceOffset 解析采样点时间戳，不带单位时为秒。
+func parseTraceOffset(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// checkTraceValue 校验采样点中的损伤参数。
+func checkTraceValue(name string, value string) error {
+	switch name {
+	case "delay", "jitter":
+		if _, _, err := splitRampValue(value); err != nil {
+			return fmt.Errorf("invalid %s: %s", name, value)
+		}
+	case "loss":
+		return checkNetemPercent(name, value)
+	case "rate":
+		if !rateRegexp.MatchString(strings.ToLower(value)) {
+			return fmt.Errorf("invalid rate: %s", value)
+		}
+	}
+	return nil
+}
+
+// loadTrace 读取轨迹文件，忽略空行、#开头的注释与表头。
+func loadTrace(path string) ([]traceSample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open trace file(%s) failed(%v)", path, err)
+	}
+	defer file.Close()
+
+	var samples []traceSample
+	var first time.Duration
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, traceColumns[0]) {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) > len(traceColumns) {
+			return nil, fmt.Errorf("trace file %s line %d: too many columns, expect %s",
+				path, lineNumber, strings.Join(traceColumns, ","))
+		}
+		offset, err := parseTraceOffset(strings.TrimSpace(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("trace file %s line %d: invalid timestamp: %s", path, lineNumber, fields[0])
+		}
+		if len(samples) == 0 {
+			first = offset
+		}
+		sample := traceSample{Offset: offset - first, Values: make(map[string]string)}
+		if len(samples) != 0 && sample.Offset < samples[len(samples)-1].Offset {
+			return nil, fmt.Errorf("trace file %s line %d: timestamp goes backwards", path, lineNumber)
+		}
+		for index := 1; index < len(fields); index++ {
+			value := strings.TrimSpace(fields[index])
+			if value == "" {
+				continue
+			}
+			if err := checkTraceValue(traceColumns[index], value); err != nil {
+				return nil, fmt.Errorf("trace file %s line %d: %v", path, lineNumber, err)
+			}
+			sample.Values[traceColumns[index]] = value
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read trace file(%s) failed(%v)", path, err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("trace file %s has no sample", path)
+	}
+	return samples, nil
+}
+
+// loadTraceFlags 读取轨迹文件，并将第一个采样点的损伤参数作为注入时的netem参数。
+func (b *baseInfo) loadTraceFlags() ([]traceSample, error) {
+	path, ok := b.flags["file"]
+	if !ok {
+		return nil, fmt.Errorf("missing param: file")
+	}
+	if mode, ok := b.flags["mode"]; ok && mode != traceOnce && mode != traceLoop {
+		return nil, fmt.Errorf("invalid mode: %s, support: %s, %s", mode, traceOnce, traceLoop)
+	}
+	samples, err := loadTrace(path)
+	if err != nil {
+		return nil, err
+	}
+	b.applySample(samples[0])
+	b.reserved = traceReserved(samples)
+	return samples, nil
+}
+
+// traceReserved 获取回放轨迹过程中会设置的netem参数，jitter属于delay参数。
+func traceReserved(samples []traceSample) []string {
+	present := make(map[string]bool)
+	for _, sample := range samples {
+		for name := range sample.Values {
+			if name == "jitter" {
+				name = "delay"
+			}
+			present[name] = true
+		}
+	}
+	var reserved []string
+	for _, name := range []string{"delay", "loss", "rate"} {
+		if present[name] {
+			reserved = append(reserved, name)
+		}
+	}
+	return reserved
+}
+
+// applySample 使用采样点的损伤参数替换当前的netem参数。
+func (b *baseInfo) applySample(sample traceSample) {
+	for _, name := range traceColumns[1:] {
+		if value, ok := sample.Values[name]; ok {
+			b.flags[name] = value
+		} else {
+			delete(b.flags, name)
+		}
+	}
+}
+
+// conditionOptions 根据网络状况参数生成netem参数，如：delay 80ms 10ms loss 1% rate 5mbit。
+// tc qdisc change会保留netem中未指定的参数，回放过程中会设置但当前采样点没有的参数使用没有损伤的值。
+func (f *netemFault) conditionOptions() []netemOption {
+	var options []netemOption
+	delay, hasDelay := f.Flags["delay"]
+	if jitter, ok := f.Flags["jitter"]; ok {
+		// netem的jitter跟在delay之后。
+		if !hasDelay {
+			delay = neutralValues["delay"]
+		}
+		options = append(options, netemOption{Name: "delay", Value: fmt.Sprintf("%s %s", delay, jitter)})
+	} else if hasDelay {
+		options = append(options, netemOption{Name: "delay", Value: delay})
+	} else if f.reserves("delay") {
+		options = append(options, netemOption{Name: "delay", Value: neutralValues["delay"]})
+	}
+	for _, name := range []string{"loss", "rate"} {
+		if value, ok := f.Flags[name]; ok {
+			options = append(options, netemOption{Name: name, Value: value})
+		} else if f.reserves(name) {
+			options = append(options, netemOption{Name: name, Value: neutralValues[name]})
+		}
+	}
+	return options
+}
+
+// reserves 判断故障运行过程中是否会设置指定的netem参数。
+func (f *netemFault) reserves(name string) bool {
+	for _, reserved := range f.Reserved {
+		if reserved == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleInterval 获取循环回放时最后一个采样点的保持时长，取最后两个采样点的间隔。
+func sampleInterval(samples []traceSample) time.Duration {
+	if len(samples) < 2 {
+		return defaultTraceInterval
+	}
+	if interval := samples[len(samples)-1].Offset - samples[len(samples)-2].Offset; interval > 0 {
+		return interval
+	}
+	return defaultTraceInterval
+}
+
+// Replay 按轨迹使用tc qdisc change原地修改netem参数，单次回放结束或stop被关闭时返回。
+func (b *baseInfo) Replay(stop <-chan struct{}) error {
+	samples, err := b.loadTraceFlags()
+	if err != nil {
+		return err
+	}
+	period := samples[len(samples)-1].Offset + sampleInterval(samples)
+
+	// 第一个采样点已在注入时生效。
+	start := time.Now()
+	for round, index := 0, 1; ; index++ {
+		if index == len(samples) {
+			if b.flags["mode"] != traceLoop {
+				return nil
+			}
+			round, index = round+1, 0
+		}
+		timer := time.NewTimer(time.Until(start.Add(time.Duration(round)*period + samples[index].Offset)))
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		b.applySample(samples[index])
+		if err := b.changeNetem(); err != nil {
+			return err
+		}
+		fmt.Printf("%s round %d sample %d %s\n", time.Now().Format(time.RFC3339), round, index,
+			netemArgs(b.fault().conditionOptions()))
+	}
+}
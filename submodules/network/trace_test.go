@@ -0,0 +1,193 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []traceSample
+		wantErr string
+	}{
+		{
+			name: "header, comments and empty columns",
+			content: `# 4G handover
+timestamp,delay,jitter,loss,rate
+10,100ms,10ms,,
+10.5,200ms,,5%,
+12,,,,1mbit
+
+13,50ms
+`,
+			want: []traceSample{
+				{Offset: 0, Values: map[string]string{"delay": "100ms", "jitter": "10ms"}},
+				{Offset: 500 * time.Millisecond, Values: map[string]string{"delay": "200ms", "loss": "5%"}},
+				{Offset: 2 * time.Second, Values: map[string]string{"rate": "1mbit"}},
+				{Offset: 3 * time.Second, Values: map[string]string{"delay": "50ms"}},
+			},
+		},
+		{
+			name:    "duration timestamp",
+			content: "0s,10ms\n1m30s,20ms\n",
+			want: []traceSample{
+				{Offset: 0, Values: map[string]string{"delay": "10ms"}},
+				{Offset: 90 * time.Second, Values: map[string]string{"delay": "20ms"}},
+			},
+		},
+		{name: "too many columns", content: "0,10ms,1ms,1%,1mbit,1\n", wantErr: "line 1: too many columns"},
+		{name: "invalid timestamp", content: "0,10ms\nnow,20ms\n", wantErr: "line 2: invalid timestamp: now"},
+		{name: "backwards", content: "2,10ms\n1,20ms\n", wantErr: "line 2: timestamp goes backwards"},
+		{name: "invalid loss", content: "0,,,120%\n", wantErr: "line 1: invalid loss: 120%"},
+		{name: "invalid rate", content: "0,,,,fast\n", wantErr: "line 1: invalid rate: fast"},
+		{name: "no sample", content: "timestamp,delay,jitter,loss,rate\n# empty\n", wantErr: "has no sample"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trace.csv")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0640); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadTrace(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadTrace() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadTrace() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadTrace() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTraceMissingFile(t *testing.T) {
+	if _, err := loadTrace(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("loadTrace() of missing file succeeded")
+	}
+}
+
+func TestTraceReserved(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []traceSample
+		want    []string
+	}{
+		{
+			name: "later samples",
+			samples: []traceSample{
+				{Values: map[string]string{"delay": "100ms"}},
+				{Values: map[string]string{"rate": "1mbit"}},
+				{Values: map[string]string{"loss": "5%"}},
+			},
+			want: []string{"delay", "loss", "rate"},
+		},
+		{
+			name:    "jitter only",
+			samples: []traceSample{{Values: map[string]string{"jitter": "10ms"}}, {Values: map[string]string{}}},
+			want:    []string{"delay"},
+		},
+		{
+			name:    "empty samples",
+			samples: []traceSample{{Values: map[string]string{}}},
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := traceReserved(tt.samples); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("traceReserved() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionOptions(t *testing.T) {
+	tests := []struct {
+		flags    map[string]string
+		reserved []string
+		want     []netemOption
+	}{
+		{
+			flags: map[string]string{"delay": "100ms", "loss": "5%", "rate": "1mbit"},
+			want:  []netemOption{{Name: "delay", Value: "100ms"}, {Name: "loss", Value: "5%"}, {Name: "rate", Value: "1mbit"}},
+		},
+		{
+			flags: map[string]string{"jitter": "10ms"},
+			want:  []netemOption{{Name: "delay", Value: "0ms 10ms"}},
+		},
+		{
+			flags: map[string]string{"file": "trace.csv"},
+			want:  nil,
+		},
+		{
+			flags:    map[string]string{"jitter": "10ms"},
+			reserved: []string{"delay", "loss", "rate"},
+			want: []netemOption{
+				{Name: "delay", Value: "0ms 10ms"}, {Name: "loss", Value: "0%"}, {Name: "rate", Value: "0bit"},
+			},
+		},
+		{
+			flags:    map[string]string{"loss": "1%"},
+			reserved: []string{"delay", "loss"},
+			want:     []netemOption{{Name: "delay", Value: "0ms"}, {Name: "loss", Value: "1%"}},
+		},
+	}
+	for _, tt := range tests {
+		fault := &netemFault{FaultType: "trace", Flags: tt.flags, Reserved: tt.reserved}
+		if got := fault.conditionOptions(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("conditionOptions(%v) = %+v, want %+v", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestReplayChangeCommands(t *testing.T) {
+	tree := &qdiscTree{Qdiscs: parseQdiscs(defaultFqCodelQdiscOutput)}
+	state, err := tree.newState("eth0")
+	if err != nil {
+		t.Fatalf("newState() error = %v", err)
+	}
+	samples := []traceSample{
+		{Values: map[string]string{"delay": "100ms", "rate": "1mbit"}},
+		{Offset: time.Second, Values: map[string]string{"delay": "50ms"}},
+	}
+	b := &baseInfo{faultType: "trace", flags: map[string]string{"interface": "eth0", "file": "trace.csv"}}
+	b.applySample(samples[0])
+	b.reserved = traceReserved(samples)
+	current := b.fault()
+	state.Faults = []*netemFault{current}
+
+	// 后一个采样点没有rate，需要显式取消前一个采样点设置的带宽限制。
+	b.applySample(samples[1])
+	current.Flags = b.fault().Flags
+	want := []string{"tc qdisc change dev eth0 root handle a0: netem delay 50ms rate 0bit"}
+	if got, err := state.changeCommands(current); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("changeCommands() = %q, %v, want %q", got, err, want)
+	}
+}
@@ -42,6 +42,8 @@ func (u *unavailable) setShellCmd() {
 	// iptables -A INPUT -i eth0 -j DROP
 	// iptables -A OUTPUT -o eth0 -j DROP
 	var inputChain, outputChain string
+	// 闪断等场景会反复调用，每次重新生成命令。
+	u.cmd = nil
 	switch u.iptablesCtl.opsType {
 	case submodules.Inject:
 		inputChain = fmt.Sprintf("iptables -A INPUT -i %s -j DROP", u.iptablesCtl.nicDevice)
@@ -106,6 +108,9 @@ func (u *unavailable) Prepare(inputArgs []string) error {
 	if err := u.iptablesCtl.dependentsCmdCheck(); err != nil {
 		return fmt.Errorf("not found iptables command in environment")
 	}
+	if err := u.iptablesCtl.preflight(u.FaultType); err != nil {
+		return err
+	}
 
 	if err := u.iptablesCtl.iptablesCtlParamsInit(inputArgs); err != nil {
 		return err
@@ -125,3 +130,7 @@ func (u *unavailable) FaultRemove(_ []string) error {
 	u.setShellCmd()
 	return u.runShellCmd()
 }
+
+func (u *unavailable) FaultVerify(_ []string) (string, error) {
+	return u.iptablesCtl.verifyDropRules([]string{"INPUT", "OUTPUT"}, u.cmd)
+}
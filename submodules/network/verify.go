@@ -0,0 +1,243 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"arsenal-hardware/util"
+)
+
+// dropCounterInterval 观察iptables DROP规则计数器增长的时间窗口。
+const dropCounterInterval = 3 * time.Second
+
+// iptablesCounterRegexp 匹配iptables-save -c输出，如：[12:1008] -A INPUT -i eth0 -p icmp -j DROP。
+var iptablesCounterRegexp = regexp.MustCompile(`^\[(\d+):\d+\] -A (\S+) (.*)$`)
+
+// parseNetemValue 将netem参数转换为数值，时间统一为微秒，速率统一为bit/s，百分比去掉百分号。
+func parseNetemValue(value string) (float64, error) {
+	value = strings.ToLower(value)
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"tbit", 1e12},
+		{"gbit", 1e9},
+		{"mbit", 1e6},
+		{"kbit", 1e3},
+		{"bit", 1},
+		{"tbps", 8e12},
+		{"gbps", 8e9},
+		{"mbps", 8e6},
+		{"kbps", 8e3},
+		{"bps", 8},
+		{"%", 1},
+		{"usec", 1},
+		{"us", 1},
+		{"msec", 1000},
+		{"ms", 1000},
+		{"sec", 1000000},
+		{"s", 1000000},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			number, err := strconv.ParseFloat(strings.TrimSuffix(value, unit.suffix), 64)
+			return number * unit.scale, err
+		}
+	}
+	// tc中不带单位的时间默认为微秒。
+	return strconv.ParseFloat(value, 64)
+}
+
+// netemParamMatch 判断netem qdisc行中的参数是否与期望值一致。
+func netemParamMatch(line string, name string, expected string) bool {
+	fields := strings.Fields(line)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] != name {
+			continue
+		}
+		actualValue, err := parseNetemValue(fields[i+1])
+		if err != nil {
+			return false
+		}
+		expectedValue, err := parseNetemValue(expected)
+		if err != nil {
+			return false
+		}
+		const tolerance = 1e-6
+		return math.Abs(actualValue-expectedValue) <= tolerance*math.Max(1, math.Abs(expectedValue))
+	}
+	return false
+}
+
+// expectedNetemParams 获取故障对应的netem参数及期望值。
+func (b *baseInfo) expectedNetemParams() map[string]string {
+	switch b.faultType {
+	case "loss", "corrupt", "duplicate":
+		if model, values, err := lossModel(b.flags); b.faultType == "loss" && err == nil && model != lossRandom {
+			// 如：loss gemodel p 2% r 20% 1-h 60% 1-k 0.1%。
+			expected := make(map[string]string)
+			for index, value := range values {
+				expected[lossModelParams[model][index]] = value
+			}
+			return expected
+		}
+		return map[string]string{b.faultType: b.flags["percent"]}
+	case "delay":
+		return map[string]string{"delay": b.flags["delay"]}
+	case "reorder":
+		return map[string]string{"delay": b.flags["delay"], "reorder": b.flags["percent"]}
+	case "rate":
+		return map[string]string{"rate": b.flags["rate"]}
+	case "trace", "profile":
+		expected := make(map[string]string)
+		for _, name := range []string{"delay", "loss", "rate"} {
+			if value, ok := b.flags[name]; ok {
+				expected[name] = value
+			}
+		}
+		return expected
+	default:
+		return map[string]string{}
+	}
+}
+
+// Verify 通过tc -s qdisc show回读netem或tbf参数，校验故障是否生效。
+// 入方向的故障回读ifb网卡上的qdisc。
+func (b *baseInfo) Verify() (string, error) {
+	nicDevice, ok := b.flags["interface"]
+	if !ok {
+		return "", fmt.Errorf("missing param: interface")
+	}
+	directions := b.directions()
+	var details []string
+	for _, direction := range directions {
+		detail, err := b.verifyDevice(directionDevice(nicDevice, direction))
+		if err != nil {
+			return "", err
+		}
+		details = append(details, fmt.Sprintf("%s: %s", direction, detail))
+	}
+	if len(directions) == 1 {
+		return strings.TrimPrefix(details[0], fmt.Sprintf("%s: ", directions[0])), nil
+	}
+	return strings.Join(details, "; "), nil
+}
+
+// verifyDevice 回读网卡上的qdisc，返回与故障参数一致的qdisc。
+func (b *baseInfo) verifyDevice(nicDevice string) (string, error) {
+	shellCmd := fmt.Sprintf("tc -s qdisc show dev %s", nicDevice)
+	result, err := util.ExecCommandBlock(shellCmd)
+	if err != nil {
+		return "", fmt.Errorf("execute: %s failed: %v, result: %s", shellCmd, err, result)
+	}
+
+	// 使用tbf限制带宽时回读tbf的参数。
+	kind := "netem"
+	if b.faultType == "rate" && b.flags["shaper"] == shaperTbf {
+		kind = shaperTbf
+	}
+	expected := b.expectedNetemParams()
+	for _, line := range strings.Split(result, "\n") {
+		if !strings.Contains(line, fmt.Sprintf(" %s ", kind)) {
+			continue
+		}
+		matched := true
+		for name, value := range expected {
+			if !netemParamMatch(line, name, value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return strings.TrimSpace(line), nil
+		}
+	}
+	return "", fmt.Errorf("%s %v not found on %s: %s", kind, expected, nicDevice, strings.TrimSpace(result))
+}
+
+// dropPackets 统计chain中引用了网卡的DROP规则已丢弃的报文数。
+func dropPackets(chain string, nicDevice string) (uint64, error) {
+	shellCmd := "iptables-save -c"
+	result, err := util.ExecCommandBlock(shellCmd)
+	if err != nil {
+		return 0, fmt.Errorf("execute: %s failed: %v, result: %s", shellCmd, err, result)
+	}
+
+	var packets uint64
+	for _, line := range strings.Split(result, "\n") {
+		match := iptablesCounterRegexp.FindStringSubmatch(line)
+		if match == nil || match[2] != chain || !strings.Contains(match[3], "-j DROP") {
+			continue
+		}
+		rule := fmt.Sprintf("%s ", match[3])
+		if !strings.Contains(rule, fmt.Sprintf("-i %s ", nicDevice)) &&
+			!strings.Contains(rule, fmt.Sprintf("-o %s ", nicDevice)) {
+			continue
+		}
+		count, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		packets += count
+	}
+	return packets, nil
+}
+
+// verifyDropRules 校验DROP规则存在，并且规则计数器在观察窗口内增长。
+func (i *iptablesCtl) verifyDropRules(chains []string, ruleCommands []string) (string, error) {
+	for _, ruleCmd := range ruleCommands {
+		checkCmd := strings.Replace(ruleCmd, "-A", "-C", 1)
+		if result, err := util.ExecCommandBlock(checkCmd); err != nil {
+			return "", fmt.Errorf("rule not found, execute: %s failed: %v, result: %s", checkCmd, err, result)
+		}
+	}
+
+	before := make([]uint64, len(chains))
+	for index, chain := range chains {
+		packets, err := dropPackets(chain, i.nicDevice)
+		if err != nil {
+			return "", err
+		}
+		before[index] = packets
+	}
+	time.Sleep(dropCounterInterval)
+
+	var details []string
+	var increased bool
+	for index, chain := range chains {
+		packets, err := dropPackets(chain, i.nicDevice)
+		if err != nil {
+			return "", err
+		}
+		if packets > before[index] {
+			increased = true
+		}
+		details = append(details, fmt.Sprintf("%s DROP packets %d -> %d", chain, before[index], packets))
+	}
+
+	detail := fmt.Sprintf("%s in %s", strings.Join(details, ", "), dropCounterInterval)
+	if !increased {
+		return "", fmt.Errorf("rules present, but no packets dropped: %s", detail)
+	}
+	return detail, nil
+}
@@ -25,6 +25,8 @@ import (
 	"strings"
 
 	"arsenal-hardware/internal/parse"
+	"arsenal-hardware/internal/topology"
+	"arsenal-hardware/submodules"
 	"arsenal-hardware/util"
 )
 
@@ -57,11 +59,23 @@ func (p *pcie) pcieBdfFormatCheck(flags map[string]string) error {
 	return nil
 }
 
-func (p *pcie) preCheck(inputArgs []string) error {
+// preCheck 检查依赖命令、bdf格式以及权限，注入时还需检查触发故障的sysfs属性文件可写。
+func (p *pcie) preCheck(faultType string, triggerAttr string, inputArgs []string) error {
+	// 故障处理对象是单例，清除上一次调用留下的bdf与备份信息。
+	*p = pcie{}
 	if err := p.dependentsCmdCheck(); err != nil {
 		return err
 	}
-	return p.pcieBdfFormatCheck(parse.TransInputFlagsToMap(inputArgs))
+	if err := p.pcieBdfFormatCheck(parse.TransInputFlagsToMap(inputArgs)); err != nil {
+		return err
+	}
+
+	preflight := util.NewPreflight(faultType)
+	preflight.RequireCapability(util.CapSysAdmin)
+	if inputArgs[submodules.OpsTypeIndex] == submodules.Inject {
+		preflight.RequireWritable(fmt.Sprintf("/sys/bus/pci/devices/%s/%s", p.bdf, triggerAttr))
+	}
+	return preflight.Check()
 }
 
 func (p *pcie) pcieDeviceIsExist() bool {
@@ -98,23 +112,17 @@ func (p *pcie) triggerPcieRefOps(opsType string, bdf string) error {
 	return nil
 }
 
+// findPcieDeviceRootBus 根据sysfs硬件拓扑查找pcie设备所在的root bus。
 func (p *pcie) findPcieDeviceRootBus() error {
-	DirPath := fmt.Sprintf("/sys/bus/pci/devices/%s", p.bdf)
-	if !util.FileIsExist(DirPath) {
-		return fmt.Errorf("not found device attr dir(%s)", DirPath)
+	if !p.pcieDeviceIsExist() {
+		return fmt.Errorf("pcie device(%s) not exist", p.bdf)
 	}
 
-	link, err := os.Readlink(DirPath)
+	rootBus, err := topology.RootBus(p.bdf)
 	if err != nil {
-		return fmt.Errorf("get device attr dir(%s) link failed", DirPath)
-	}
-
-	re := regexp.MustCompile(`[0-9a-fA-F]{4}:[0-9a-fA-F]{2}`)
-	pcieRootBus := re.FindString(link)
-	if pcieRootBus == "" {
-		return fmt.Errorf("get root bus from link(%s) failed", link)
+		return err
 	}
-	p.rootBus = pcieRootBus
+	p.rootBus = rootBus
 	return nil
 }
 
@@ -176,6 +184,8 @@ func (p *pcie) backupPcieRootBusInfo() error {
 		return fmt.Errorf("create root bus backup file failed(%v)", err)
 	}
 	defer file.Close()
+	// 注入失败时据此删除备份文件。
+	p.backupBdfRootBusInfoFilePath = backupInfoFilePath
 	return nil
 }
 
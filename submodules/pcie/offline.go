@@ -35,7 +35,7 @@ type offline struct {
 }
 
 func (o *offline) Prepare(inputArgs []string) error {
-	return o.pcie.preCheck(inputArgs)
+	return o.pcie.preCheck(o.FaultType, "remove", inputArgs)
 }
 
 func (o *offline) FaultInject(_ []string) error {
@@ -70,3 +70,11 @@ func (o *offline) FaultRemove(_ []string) error {
 	}
 	return o.pcie.removePcieRootBusInfo()
 }
+
+func (o *offline) FaultVerify(_ []string) (string, error) {
+	// 设备移除后/sys/bus/pci/devices下对应的目录会消失。
+	if o.pcie.pcieDeviceIsExist() {
+		return "", fmt.Errorf("pcie device(%s) still exist", o.pcie.bdf)
+	}
+	return fmt.Sprintf("/sys/bus/pci/devices/%s not exist", o.pcie.bdf), nil
+}
@@ -35,7 +35,7 @@ type resetAbnormal struct {
 }
 
 func (r *resetAbnormal) Prepare(inputArgs []string) error {
-	return r.pcie.preCheck(inputArgs)
+	return r.pcie.preCheck(r.FaultType, "reset", inputArgs)
 }
 
 func (r *resetAbnormal) FaultInject(_ []string) error {
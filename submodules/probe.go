@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"fmt"
+
+	"arsenal-hardware/internal/event"
+	"arsenal-hardware/internal/probe"
+	"arsenal-hardware/internal/state"
+)
+
+// loadProbes 读取--probes指定的稳态探针配置，未指定时返回空。
+func loadProbes(options map[string]string) ([]*probe.Probe, error) {
+	probesPath := options["probes"]
+	if probesPath == "" {
+		return nil, nil
+	}
+	return probe.Load(probesPath)
+}
+
+func hasDuringProbes(probes []*probe.Probe) bool {
+	for _, p := range probes {
+		if p.HasPhase(probe.During) {
+			return true
+		}
+	}
+	return false
+}
+
+// runProbeWatch 稳态检查后台进程入口，检查失败达到阈值时记录中止原因并立即清理故障。
+func runProbeWatch(faultType string, faultArgs []string, options map[string]string) error {
+	probes, err := loadProbes(options)
+	if err != nil {
+		return err
+	}
+	result := probe.Watch(probes, stopOnSignal())
+	if result == nil {
+		return nil
+	}
+
+	record, err := findRecord(faultType, faultArgs)
+	if err != nil || record == nil {
+		return fmt.Errorf("find %s state failed(%v)", faultType, err)
+	}
+	record.AbortReason = result.String()
+	record.ProbeResults = append(record.ProbeResults, *result)
+	if err := state.Save(record); err != nil {
+		return fmt.Errorf("save %s state failed(%v)", faultType, err)
+	}
+
+	emitEvent(event.Aborted, faultType, faultArgs, record.Options, record.AbortReason)
+	fmt.Printf("%s aborted: %s\n", faultType, record.AbortReason)
+	removeArgs := append(withOpsType(faultArgs, Remove), "--probes", options["probes"])
+	return RunCmd(removeArgs)
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"arsenal-hardware/internal/event"
+	"arsenal-hardware/internal/parse"
+	"arsenal-hardware/internal/policy"
+	"arsenal-hardware/internal/probe"
+	"arsenal-hardware/internal/snapshot"
+	"arsenal-hardware/internal/state"
+	"arsenal-hardware/util"
+)
+
+// frameworkFlags 由框架处理的参数，不会传递给具体故障模式。
+var frameworkFlags = map[string]bool{
+	// 忽略安全策略强制注入：--policy-override true。
+	"policy-override": true,
+	// 注入后校验故障是否生效：--verify true。
+	"verify": true,
+	// 稳态探针配置文件：--probes /path/to/probes.json。
+	"probes": true,
+	// 批量注入的并发数：--batch-workers 4。
+	"batch-workers": true,
+	// 周期性闪断：--flap-on 10s --flap-off 5s --flap-jitter 1s --flap-cycles 10。
+	"flap-on":     true,
+	"flap-off":    true,
+	"flap-jitter": true,
+	"flap-cycles": true,
+	// 参数渐变：--ramp-to 30% --ramp-duration 10m --ramp-schedule linear --ramp-interval 1s --ramp-steps 10。
+	"ramp-to":       true,
+	"ramp-duration": true,
+	"ramp-schedule": true,
+	"ramp-interval": true,
+	"ramp-steps":    true,
+}
+
+// forwardedFlags 由框架记录在状态记录的Options中，同时传递给故障模式的框架参数，
+// 不属于故障目标与故障参数，清理时不需要再次指定。
+var forwardedFlags = map[string]bool{
+	"ramp-to":       true,
+	"ramp-duration": true,
+	"ramp-schedule": true,
+	"ramp-interval": true,
+	"ramp-steps":    true,
+}
+
+// splitFrameworkFlags 将输入参数拆分为故障模式参数与框架参数，forwardedFlags同时保留在故障模式参数中。
+func splitFrameworkFlags(inputArgs []string) ([]string, map[string]string) {
+	faultArgs := make([]string, 0, len(inputArgs))
+	options := make(map[string]string)
+	for index := 0; index < len(inputArgs); index++ {
+		arg := inputArgs[index]
+		name := strings.TrimPrefix(arg, "--")
+		if index > FaultTypeIndex && name != arg && frameworkFlags[name] && index+1 < len(inputArgs) {
+			options[name] = inputArgs[index+1]
+			if forwardedFlags[name] {
+				faultArgs = append(faultArgs, arg, inputArgs[index+1])
+			}
+			index++
+			continue
+		}
+		faultArgs = append(faultArgs, arg)
+	}
+	return faultArgs, options
+}
+
+// recordFlags 获取保存在状态记录中、用于匹配状态记录的故障参数，不包括forwardedFlags。
+func recordFlags(faultArgs []string) map[string]string {
+	flags := parse.TransInputFlagsToMap(faultArgs)
+	for name := range forwardedFlags {
+		delete(flags, name)
+	}
+	return flags
+}
+
+// findRecord 查找故障参数对应的处于注入状态的状态记录，只忽略框架参数的差异。
+func findRecord(faultType string, faultArgs []string) (*state.Record, error) {
+	return state.FindActive(faultType, recordFlags(faultArgs), frameworkFlags)
+}
+
+// checkPolicy 检查故障注入是否违反安全策略，指定--policy-override true时只告警不拦截。
+func checkPolicy(flags map[string]string, options map[string]string, newFaults int) error {
+	p, err := policy.Load()
+	if err != nil {
+		return err
+	}
+	active, err := state.Active()
+	if err != nil {
+		return fmt.Errorf("get active faults failed(%v)", err)
+	}
+	return enforcePolicy(p, flags, options, len(active), newFaults)
+}
+
+// enforcePolicy 按安全策略检查故障注入，违反策略且未指定--policy-override true时拦截。
+func enforcePolicy(p *policy.Policy, flags map[string]string, options map[string]string,
+	activeFaults int, newFaults int) error {
+	if err := p.Check(flags, activeFaults, newFaults); err != nil {
+		if options["policy-override"] != "true" {
+			return fmt.Errorf("%v, use --policy-override true to force inject", err)
+		}
+		fmt.Printf("warning: %v, overridden\n", err)
+	}
+	return nil
+}
+
+// injectFault 检查安全策略与稳态后注入故障，注入成功后保存状态记录。
+func injectFault(ops FaultOperationType, handler FaultOperations, faultType string,
+	faultArgs []string, options map[string]string) error {
+	flags := recordFlags(faultArgs)
+	if err := checkPolicy(flags, options, 1); err != nil {
+		return err
+	}
+
+	record := state.New(faultType, flags)
+	record.Options = options
+	probes, err := loadProbes(options)
+	if err != nil {
+		return err
+	}
+	if record.ProbeResults, err = probe.RunPhase(probes, probe.Before); err != nil {
+		return fmt.Errorf("%s not injected, steady-state check failed: %v", faultType, err)
+	}
+
+	record.BeforeSnapshot = snapshot.Capture()
+	if _, ok := options["flap-on"]; ok {
+		// 闪断进程第一次注入后再输出事件并记录审计日志。
+		if err := startFlap(record, faultArgs, options); err != nil {
+			return err
+		}
+	} else if err := injectAndSave(ops, handler, record, faultArgs, options); err != nil {
+		return err
+	}
+
+	if hasDuringProbes(probes) {
+		probeArgs := append(workerArgs(faultArgs, ProbeWatch), "--probes", options["probes"])
+		if err := startWorker(record, ProbeWatch, probeArgs, map[string]string{"probes": options["probes"]}); err != nil {
+			return fmt.Errorf("%s injected, but %v", faultType, err)
+		}
+	}
+
+	if record.Verification != nil && !record.Verification.Passed {
+		return fmt.Errorf("%s injected, but verification failed: %s", faultType, record.Verification.Detail)
+	}
+	return nil
+}
+
+// injectAndSave 注入故障并保存状态记录，故障模式需要后台进程时一并启动。
+func injectAndSave(ops FaultOperationType, handler FaultOperations, record *state.Record,
+	faultArgs []string, options map[string]string) error {
+	if err := ops(handler, faultArgs); err != nil {
+		return err
+	}
+	markInjected(record.FaultType, faultArgs, options)
+	if options["verify"] == "true" {
+		record.Verification = verifyFault(handler, record.FaultType, faultArgs)
+		if record.Verification != nil && record.Verification.Passed {
+			emitEvent(event.Verified, record.FaultType, faultArgs, options, record.Verification.Detail)
+		}
+	}
+	if worker, ok := handler.(FaultWorker); ok && worker.NeedWorker(faultArgs) {
+		if err := startWorker(record, Work, workerArgs(faultArgs, Work), nil); err != nil {
+			return fmt.Errorf("%s injected, but %v", record.FaultType, err)
+		}
+	} else if err := state.Save(record); err != nil {
+		return fmt.Errorf("%s injected, but save state failed(%v)", record.FaultType, err)
+	}
+	return nil
+}
+
+// markInjected 故障生效后立即输出事件并记录审计日志，之后的校验等步骤失败时故障仍处于注入状态。
+func markInjected(faultType string, faultArgs []string, options map[string]string) {
+	emitEvent(event.Injected, faultType, faultArgs, options, "")
+	writeAudit(faultType, Inject, faultArgs, options, nil)
+}
+
+// verifyFault 校验故障是否真正生效并输出校验结果，故障模式不支持校验时返回nil。
+func verifyFault(handler FaultOperations, faultType string, faultArgs []string) *state.Verification {
+	verifier, ok := handler.(FaultVerifier)
+	if !ok {
+		fmt.Printf("%s not support verification, skipped\n", faultType)
+		return nil
+	}
+
+	verification := &state.Verification{Time: time.Now()}
+	detail, err := verifier.FaultVerify(faultArgs)
+	if err != nil {
+		verification.Detail = err.Error()
+		return verification
+	}
+	verification.Passed = true
+	verification.Detail = detail
+	fmt.Printf("%s verification passed: %s\n", faultType, detail)
+	return verification
+}
+
+// removeFault 清理故障，并将对应的状态记录标记为已清理。
+func removeFault(ops FaultOperationType, handler FaultOperations, faultType string,
+	faultArgs []string, options map[string]string) error {
+	record, err := findRecord(faultType, faultArgs)
+	if err != nil {
+		return fmt.Errorf("find %s state failed(%v)", faultType, err)
+	}
+	// 框架引入状态记录之前注入的故障没有对应记录。
+	if record == nil {
+		return ops(handler, faultArgs)
+	}
+
+	// 闪断进程收到退出信号后会自行恢复目标，不需要再执行故障清理。
+	flapWorker := record.Worker(Flap)
+	restored := flapWorker != nil && util.ProcessIsAlive(flapWorker.Pid, OpsTypeIndex, Flap)
+	for _, worker := range record.Workers {
+		if err := stopWorker(worker); err != nil {
+			return err
+		}
+	}
+	if !restored {
+		if err := ops(handler, faultArgs); err != nil {
+			// 闪断进程异常退出时无法确定故障是否处于注入阶段，清理失败只告警。
+			if flapWorker == nil {
+				return err
+			}
+			fmt.Printf("warning: %s remove failed(%v)\n", faultType, err)
+		}
+	}
+
+	if _, ok := options["probes"]; !ok {
+		options["probes"] = record.Options["probes"]
+	}
+	probes, err := loadProbes(options)
+	if err != nil {
+		return err
+	}
+	results, probeErr := probe.RunPhase(probes, probe.After)
+	record.ProbeResults = append(record.ProbeResults, results...)
+	if err := finishRecord(record); err != nil {
+		return err
+	}
+	if probeErr != nil {
+		return fmt.Errorf("%s removed, but steady-state check failed: %v", faultType, probeErr)
+	}
+	return nil
+}
+
+// finishRecord 将状态记录标记为已清理，对比快照并保存，存在恢复遗漏时返回错误。
+func finishRecord(record *state.Record) error {
+	var err error
+	record.Status = state.Removed
+	record.RemoveTime = time.Now()
+	if record.BeforeSnapshot != nil {
+		record.AfterSnapshot = snapshot.Capture()
+		if record.RestoreLeaks, err = getRestoreLeaks(record); err != nil {
+			return err
+		}
+	}
+	if err := state.Save(record); err != nil {
+		return fmt.Errorf("%s removed, but save state failed(%v)", record.FaultType, err)
+	}
+
+	if len(record.RestoreLeaks) != 0 {
+		leaks := make([]string, 0, len(record.RestoreLeaks))
+		for _, leak := range record.RestoreLeaks {
+			leaks = append(leaks, leak.String())
+		}
+		return fmt.Errorf("%s removed, but restore leak detected: %s", record.FaultType, strings.Join(leaks, "; "))
+	}
+	return nil
+}
+
+// getRestoreLeaks 对比注入前与清理后的快照，忽略其他处于注入状态的故障目标上的差异。
+func getRestoreLeaks(record *state.Record) ([]snapshot.Leak, error) {
+	active, err := state.Active()
+	if err != nil {
+		return nil, fmt.Errorf("get active faults failed(%v)", err)
+	}
+	return restoreLeaks(record, active), nil
+}
+
+// restoreLeaks 获取快照差异中不属于active中其他故障目标的条目。
+func restoreLeaks(record *state.Record, active []*state.Record) []snapshot.Leak {
+	otherTargets := make(map[string]bool)
+	for _, r := range active {
+		if _, name := r.Target(); r.ID != record.ID && name != "" {
+			otherTargets[name] = true
+		}
+	}
+
+	var leaks []snapshot.Leak
+	for _, leak := range snapshot.Diff(record.BeforeSnapshot, record.AfterSnapshot) {
+		if !otherTargets[leak.Subject] {
+			leaks = append(leaks, leak)
+		}
+	}
+	return leaks
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"arsenal-hardware/internal/policy"
+	"arsenal-hardware/internal/snapshot"
+	"arsenal-hardware/internal/state"
+)
+
+func TestEnforcePolicy(t *testing.T) {
+	p := &policy.Policy{MaxFaults: 2}
+	flags := map[string]string{"interface": "eth1"}
+	tests := []struct {
+		name         string
+		options      map[string]string
+		activeFaults int
+		wantErr      string
+	}{
+		{name: "allowed", options: map[string]string{}, activeFaults: 1},
+		{
+			name:         "blocked",
+			options:      map[string]string{},
+			activeFaults: 2,
+			wantErr:      "max-faults 2 exceeded, 2 faults already injected, use --policy-override true to force inject",
+		},
+		{
+			name:         "override not true",
+			options:      map[string]string{"policy-override": "yes"},
+			activeFaults: 2,
+			wantErr:      "use --policy-override true to force inject",
+		},
+		{name: "overridden", options: map[string]string{"policy-override": "true"}, activeFaults: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := enforcePolicy(p, flags, tt.options, tt.activeFaults, 1)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("enforcePolicy() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("enforcePolicy() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestoreLeaks(t *testing.T) {
+	record := &state.Record{
+		ID:             "network-down-1",
+		FaultType:      "network-down",
+		Flags:          map[string]string{"interface": "eth1"},
+		BeforeSnapshot: snapshot.Snapshot{"link": {"eth1 up", "eth2 up", "eth3 up"}},
+		AfterSnapshot:  snapshot.Snapshot{"link": {"eth1 down", "eth2 down", "eth3 down"}},
+	}
+	leaks := func(names ...string) []snapshot.Leak {
+		var result []snapshot.Leak
+		for _, name := range names {
+			result = append(result,
+				snapshot.Leak{Section: "link", Subject: name, Change: "added", Line: name + " down"},
+				snapshot.Leak{Section: "link", Subject: name, Change: "missing", Line: name + " up"})
+		}
+		return result
+	}
+	tests := []struct {
+		name   string
+		active []*state.Record
+		want   []snapshot.Leak
+	}{
+		{name: "no other fault", active: []*state.Record{record}, want: leaks("eth1", "eth2", "eth3")},
+		{
+			name: "other target active",
+			active: []*state.Record{record,
+				{ID: "network-down-2", FaultType: "network-down", Flags: map[string]string{"interface": "eth2"}}},
+			want: leaks("eth1", "eth3"),
+		},
+		{
+			name: "other fault on same target",
+			active: []*state.Record{
+				{ID: "network-delay-3", FaultType: "network-delay", Flags: map[string]string{"interface": "eth1", "delay": "10ms"}}},
+			want: leaks("eth2", "eth3"),
+		},
+		{
+			name:   "fault without target",
+			active: []*state.Record{{ID: "cpu-load-4", FaultType: "cpu-load", Flags: map[string]string{"percent": "50"}}},
+			want:   leaks("eth1", "eth2", "eth3"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restoreLeaks(record, tt.active); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restoreLeaks() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitFrameworkFlags(t *testing.T) {
+	tests := []struct {
+		name          string
+		inputArgs     string
+		wantFaultArgs string
+		wantOptions   map[string]string
+	}{
+		{
+			name:          "fault flags only",
+			inputArgs:     "ah inject network delay --interface eth1 --delay 100ms",
+			wantFaultArgs: "ah inject network delay --interface eth1 --delay 100ms",
+			wantOptions:   map[string]string{},
+		},
+		{
+			name:          "framework flags",
+			inputArgs:     "ah inject network down --verify true --interface eth1 --flap-on 10s --flap-off 5s --probes p.json",
+			wantFaultArgs: "ah inject network down --interface eth1",
+			wantOptions: map[string]string{"verify": "true", "flap-on": "10s", "flap-off": "5s",
+				"probes": "p.json"},
+		},
+		{
+			name:          "forwarded ramp flags",
+			inputArgs:     "ah inject network loss --interface eth1 --percent 10% --ramp-to 50% --ramp-duration 1m --policy-override true",
+			wantFaultArgs: "ah inject network loss --interface eth1 --percent 10% --ramp-to 50% --ramp-duration 1m",
+			wantOptions:   map[string]string{"ramp-to": "50%", "ramp-duration": "1m", "policy-override": "true"},
+		},
+		{
+			name:          "framework flag without value",
+			inputArgs:     "ah inject network down --interface eth1 --verify",
+			wantFaultArgs: "ah inject network down --interface eth1 --verify",
+			wantOptions:   map[string]string{},
+		},
+		{
+			name:          "framework flag name as value",
+			inputArgs:     "ah inject network delay --interface eth1 --delay --verify",
+			wantFaultArgs: "ah inject network delay --interface eth1 --delay --verify",
+			wantOptions:   map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			faultArgs, options := splitFrameworkFlags(strings.Fields(tt.inputArgs))
+			if got := strings.Join(faultArgs, " "); got != tt.wantFaultArgs {
+				t.Errorf("faultArgs = %q, want %q", got, tt.wantFaultArgs)
+			}
+			if !reflect.DeepEqual(options, tt.wantOptions) {
+				t.Errorf("options = %v, want %v", options, tt.wantOptions)
+			}
+		})
+	}
+}
+
+func TestRecordFlags(t *testing.T) {
+	faultArgs := strings.Fields("ah remove network loss --interface eth1 --percent 10% --ramp-to 50% --ramp-steps 5")
+	want := map[string]string{"interface": "eth1", "percent": "10%"}
+	if got := recordFlags(faultArgs); !reflect.DeepEqual(got, want) {
+		t.Errorf("recordFlags() = %v, want %v", got, want)
+	}
+	for name := range forwardedFlags {
+		if !frameworkFlags[name] {
+			t.Errorf("forwarded flag %s is not a framework flag", name)
+		}
+	}
+}
@@ -18,10 +18,20 @@ package submodules
 
 import (
 	"fmt"
+	"time"
+
+	"arsenal-hardware/internal/audit"
+	"arsenal-hardware/internal/event"
+	"arsenal-hardware/internal/hook"
+	"arsenal-hardware/internal/impact"
+	"arsenal-hardware/internal/parse"
 )
 
 type FaultOperationType func(faultType FaultOperations, inputArgs []string) error
 
+// CommandType 不依赖具体故障模式的独立命令，如：arsenal-hardware doctor。
+type CommandType func(inputArgs []string) error
+
 var (
 	// OpsTypeIndex 操作类型在输入参数中的索引。
 	OpsTypeIndex = 1
@@ -33,10 +43,18 @@ var (
 	Inject = "inject"
 	// Remove 故障清理字符串标志。
 	Remove = "remove"
+	// Flap 周期性闪断后台进程字符串标志，由框架在注入时启动。
+	Flap = "flap"
+	// Work 故障模式后台进程字符串标志，由框架在注入时启动。
+	Work = "work"
+	// ProbeWatch 故障期间稳态检查后台进程字符串标志，由框架在注入时启动。
+	ProbeWatch = "probe"
 	// FaultOperationTypes 故障操作类型集合。
 	FaultOperationTypes = map[string]FaultOperationType{}
 	// FaultTypes 故障模式对应处理函数集合。
 	FaultTypes = map[string]FaultOperations{}
+	// Commands 独立命令集合。
+	Commands = map[string]CommandType{}
 )
 
 // Add 向故障模式处理函数集合中添加元素。
@@ -44,6 +62,11 @@ func Add(name string, newFaultType FaultOperations) {
 	FaultTypes[name] = newFaultType
 }
 
+// AddCommand 向独立命令集合中添加元素。
+func AddCommand(name string, command CommandType) {
+	Commands[name] = command
+}
+
 type FaultOperations interface {
 	// Prepare 操作前的准备工作。
 	Prepare([]string) error
@@ -53,6 +76,20 @@ type FaultOperations interface {
 	FaultRemove([]string) error
 }
 
+// FaultVerifier 能够校验故障是否真正生效的故障模式实现该接口。
+type FaultVerifier interface {
+	// FaultVerify 校验故障是否生效，返回校验详情，未生效时返回错误。
+	FaultVerify([]string) (string, error)
+}
+
+// FaultWorker 注入后需要后台进程持续调整故障的故障模式实现该接口，如：tc参数渐变。
+type FaultWorker interface {
+	// NeedWorker 根据输入参数判断注入后是否需要启动后台进程。
+	NeedWorker([]string) bool
+	// FaultWork 后台进程入口，阻塞运行直到完成或stop被关闭，不负责清理故障。
+	FaultWork(inputArgs []string, stop <-chan struct{}) error
+}
+
 func RunCmd(inputArgs []string) error {
 	// 检查是否支持对应的faultType。
 	faultTypeKey := fmt.Sprintf("%s-%s", inputArgs[ModuleNameIndex], inputArgs[FaultTypeIndex])
@@ -61,17 +98,82 @@ func RunCmd(inputArgs []string) error {
 		return fmt.Errorf("unsupported fault type: %s", faultTypeKey)
 	}
 
+	// 多个目标时每个目标单独执行，各自执行钩子并记录审计日志；
+	// 去掉空目标后只剩一个目标时，如：--interface eth0,，按单个目标执行。
+	opsType := inputArgs[OpsTypeIndex]
+	valueIndex, targets := batchTargets(inputArgs)
+	if len(targets) == 1 {
+		inputArgs = targetArgs(inputArgs, valueIndex, targets[0])
+	}
+
+	// 框架参数在此处拆分，故障模式只处理自身的参数。
+	faultArgs, options := splitFrameworkFlags(inputArgs)
+
+	// 故障注入与清理需要执行钩子并记录审计日志。
+	if opsType == Inject || opsType == Remove {
+		if len(targets) > 1 {
+			return runBatch(inputArgs, faultTypeKey, valueIndex, targets, options)
+		}
+		err := runFaultOps(handler, faultTypeKey, opsType, faultArgs, options)
+		if err != nil {
+			emitEvent(event.Failed, faultTypeKey, faultArgs, options, err.Error())
+		}
+		// 注入成功的审计日志在故障生效时已经记录，闪断故障由后台进程在第一次注入后记录。
+		if err != nil || opsType == Remove {
+			writeAudit(faultTypeKey, opsType, faultArgs, options, err)
+		}
+		return err
+	}
+	if opsType == "prepare" {
+		if err := runHook(hook.PrePrepare, faultTypeKey, faultArgs, options); err != nil {
+			err = fmt.Errorf("%s prepare aborted, %v", faultTypeKey, err)
+			emitEvent(event.Failed, faultTypeKey, faultArgs, options, err.Error())
+			return err
+		}
+	}
+
 	// 如果是阻塞执行先非阻塞执行只执行prepare，做一些前置检查，前置检查不通过肯定是失败的。
-	if err := handler.Prepare(inputArgs); err != nil {
+	if err := handler.Prepare(faultArgs); err != nil {
+		emitEvent(event.Failed, faultTypeKey, faultArgs, options, err.Error())
 		return err
 	}
 
-	if inputArgs[OpsTypeIndex] == "prepare" {
+	switch opsType {
+	case "prepare":
+		emitEvent(event.Prepared, faultTypeKey, faultArgs, options, "")
+		// 输出故障目标的爆炸半径，供注入前评估影响范围。
+		if report := impact.Preview(parse.TransInputFlagsToMap(faultArgs)); report != nil {
+			fmt.Println(report)
+		}
 		return nil
+	case Flap:
+		return runFlap(handler, faultTypeKey, faultArgs, options)
+	case Work:
+		return runWork(handler, faultTypeKey, faultArgs)
+	case ProbeWatch:
+		return runProbeWatch(faultTypeKey, faultArgs, options)
 	}
-	ops, ok := FaultOperationTypes[inputArgs[OpsTypeIndex]]
+	ops, ok := FaultOperationTypes[opsType]
 	if !ok {
-		return fmt.Errorf("unsupported operation type: %s", inputArgs[ModuleNameIndex])
+		return fmt.Errorf("unsupported operation type: %s", opsType)
+	}
+	return ops(handler, faultArgs)
+}
+
+// writeAudit 记录故障注入与清理操作，审计日志写入失败只告警。
+func writeAudit(faultType string, opsType string, faultArgs []string, options map[string]string, opsErr error) {
+	entry := &audit.Entry{
+		Time:      time.Now(),
+		Ops:       opsType,
+		FaultType: faultType,
+		Flags:     parse.TransInputFlagsToMap(faultArgs),
+		Options:   options,
+		Succeeded: opsErr == nil,
+	}
+	if opsErr != nil {
+		entry.Error = opsErr.Error()
+	}
+	if err := audit.Write(entry); err != nil {
+		fmt.Printf("warning: %v\n", err)
 	}
-	return ops(handler, inputArgs)
 }
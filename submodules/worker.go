@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package submodules
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"arsenal-hardware/internal/event"
+	"arsenal-hardware/internal/state"
+	"arsenal-hardware/util"
+)
+
+// startWorker 先保存状态记录再启动后台进程，后台进程依赖状态记录完成收尾。
+func startWorker(record *state.Record, mode string, args []string, options map[string]string) error {
+	logPath, err := state.LogPath(record.ID, mode)
+	if err != nil {
+		return err
+	}
+	worker := &state.Worker{Mode: mode, Options: options, LogPath: logPath}
+	record.Workers = append(record.Workers, worker)
+	if err := state.Save(record); err != nil {
+		return fmt.Errorf("save %s state failed(%v)", record.FaultType, err)
+	}
+
+	pid, err := util.StartSelfDetached(args, logPath)
+	if err != nil {
+		// 闪断进程启动前故障尚未注入，其他后台进程启动前故障已经注入。
+		if mode == Flap {
+			record.Status = state.Removed
+		}
+		worker.Error = err.Error()
+		if saveErr := state.Save(record); saveErr != nil {
+			return fmt.Errorf("start %s worker failed(%v), save state failed(%v)", mode, err, saveErr)
+		}
+		return fmt.Errorf("start %s worker failed(%v)", mode, err)
+	}
+	worker.Pid = pid
+	if err := state.Save(record); err != nil {
+		return fmt.Errorf("save %s state failed(%v)", record.FaultType, err)
+	}
+	fmt.Printf("%s %s worker started, pid: %d, log: %s\n", record.FaultType, mode, pid, logPath)
+	return nil
+}
+
+// stopWorker 通知后台进程退出并等待其结束，稳态检查进程中止故障时不需要停止自身。
+func stopWorker(worker *state.Worker) error {
+	if worker.Pid == os.Getpid() || !util.ProcessIsAlive(worker.Pid, OpsTypeIndex, worker.Mode) {
+		return nil
+	}
+	if err := syscall.Kill(worker.Pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("stop %s worker(%d) failed(%v)", worker.Mode, worker.Pid, err)
+	}
+
+	const timeout, interval = 60 * time.Second, 100 * time.Millisecond
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(interval) {
+		if !util.ProcessIsAlive(worker.Pid, OpsTypeIndex, worker.Mode) {
+			return nil
+		}
+	}
+	return fmt.Errorf("wait %s worker(%d) exit timeout", worker.Mode, worker.Pid)
+}
+
+// waitOrStop 等待指定时长，期间收到退出信号时返回true。
+func waitOrStop(duration time.Duration, stop <-chan os.Signal) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// workerArgs 生成后台进程的启动参数，即把操作类型替换为后台进程类型。
+func workerArgs(faultArgs []string, mode string) []string {
+	return withOpsType(faultArgs, mode)[OpsTypeIndex:]
+}
+
+// runWork 后台进程入口，收到SIGTERM时通知故障模式停止，故障清理由清理流程完成。
+func runWork(handler FaultOperations, faultType string, faultArgs []string) error {
+	worker, ok := handler.(FaultWorker)
+	if !ok {
+		return fmt.Errorf("%s not support worker", faultArgs[FaultTypeIndex])
+	}
+
+	stop := stopOnSignal()
+	workErr := worker.FaultWork(faultArgs, stop)
+	// 被清理流程停止时由清理流程输出事件。
+	select {
+	case <-stop:
+		return workErr
+	default:
+	}
+	var options map[string]string
+	if record, err := findRecord(faultType, faultArgs); err == nil && record != nil {
+		options = record.Options
+	}
+	emitExpired(Work, faultType, faultArgs, options, workErr)
+	return workErr
+}
+
+// emitExpired 后台进程自行结束时输出expired事件，事件详情为进程类型及错误信息。
+func emitExpired(mode string, faultType string, faultArgs []string, options map[string]string, workerErr error) {
+	detail := fmt.Sprintf("%s worker finished", mode)
+	if workerErr != nil {
+		detail = fmt.Sprintf("%s worker failed(%v)", mode, workerErr)
+	}
+	emitEvent(event.Expired, faultType, faultArgs, options, detail)
+}
+
+// stopOnSignal 收到SIGTERM或SIGINT时关闭返回的通道。
+func stopOnSignal() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	stop := make(chan struct{})
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	return stop
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Capability Linux capability编号，详见capabilities(7)。
+type Capability uint
+
+const (
+	// CapNetAdmin tc、iptables以及网卡启停依赖的权限。
+	CapNetAdmin Capability = 12
+	// CapSysAdmin 写sysfs控制文件依赖的权限。
+	CapSysAdmin Capability = 21
+)
+
+// accessWriteOK access(2)的W_OK标志。
+const accessWriteOK = 0x2
+
+var capabilityNames = map[Capability]string{
+	CapNetAdmin: "CAP_NET_ADMIN",
+	CapSysAdmin: "CAP_SYS_ADMIN",
+}
+
+func (c Capability) String() string {
+	if name, ok := capabilityNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("CAP_%d", uint(c))
+}
+
+// HasCapability 判断当前进程的有效capability集合中是否包含指定的capability。
+func HasCapability(c Capability) (bool, error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// 示例：CapEff:	000001ffffffffff
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		capEff, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("parse CapEff(%s) failed(%v)", line, err)
+		}
+		return capEff&(1<<uint(c)) != 0, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, fmt.Errorf("not found CapEff in /proc/self/status")
+}
+
+// FileIsWritable 判断当前进程是否对文件有写权限。
+func FileIsWritable(path string) bool {
+	return syscall.Access(path, accessWriteOK) == nil
+}
+
+// KernelModuleIsLoaded 判断内核模块是否已加载或已编译进内核。
+func KernelModuleIsLoaded(name string) bool {
+	if FileIsExist(fmt.Sprintf("/sys/module/%s", name)) {
+		return true
+	}
+
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+	builtinPath := fmt.Sprintf("/lib/modules/%s/modules.builtin", strings.TrimSpace(string(release)))
+	content, err := ioutil.ReadFile(builtinPath)
+	if err != nil {
+		return false
+	}
+	// modules.builtin中每行为模块的相对路径，如：kernel/net/sched/sch_netem.ko。
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasSuffix(line, fmt.Sprintf("/%s.ko", name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Preflight 故障注入前置检查，汇总故障模式缺失的权限、sysfs写权限与内核模块。
+type Preflight struct {
+	faultType string
+	missing   []string
+}
+
+// NewPreflight 创建指定故障模式的前置检查。
+func NewPreflight(faultType string) *Preflight {
+	return &Preflight{faultType: faultType}
+}
+
+// RequireCapability 要求当前进程拥有指定的capability。
+func (p *Preflight) RequireCapability(c Capability) {
+	ok, err := HasCapability(c)
+	if err != nil {
+		p.missing = append(p.missing, fmt.Sprintf("capability %s unknown(%v)", c, err))
+		return
+	}
+	if !ok {
+		p.missing = append(p.missing, fmt.Sprintf("capability %s", c))
+	}
+}
+
+// RequireWritable 要求sysfs属性文件存在且可写。
+func (p *Preflight) RequireWritable(path string) {
+	if !FileIsExist(path) {
+		p.missing = append(p.missing, fmt.Sprintf("sysfs attribute %s not found", path))
+		return
+	}
+	if !FileIsWritable(path) {
+		p.missing = append(p.missing, fmt.Sprintf("sysfs attribute %s not writable", path))
+	}
+}
+
+// RequireKernelModule 要求内核模块已加载。
+func (p *Preflight) RequireKernelModule(name string) {
+	if !KernelModuleIsLoaded(name) {
+		p.missing = append(p.missing, fmt.Sprintf("kernel module %s not loaded", name))
+	}
+}
+
+// Check 返回前置检查结果，所有条件满足时返回nil。
+func (p *Preflight) Check() error {
+	if len(p.missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s preflight check failed, missing: %s", p.faultType, strings.Join(p.missing, "; "))
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Sangfor Technologies Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// bdfRegexp 匹配pcie设备bdf，如：0000:3b:00.0。
+var bdfRegexp = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-9a-f]$`)
+
+// IsBdf 判断名称是否为pcie设备bdf。
+func IsBdf(name string) bool {
+	return bdfRegexp.MatchString(name)
+}
+
+// ReadProcTable 按行读取/proc下的表格文件，每行按空白字符拆分为字段。
+func ReadProcTable(path string) [][]string {
+	var table [][]string
+	file, err := os.Open(path)
+	if err != nil {
+		return table
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		table = append(table, strings.Fields(scanner.Text()))
+	}
+	return table
+}
+
+// DeviceDisks 获取主次设备号对应块设备所在的物理磁盘。
+func DeviceDisks(major int, minor int) []string {
+	devPath, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return nil
+	}
+	return BlockDisks(filepath.Base(devPath))
+}
+
+// BlockDisks 将分区、device mapper等块设备解析为底层物理磁盘。
+func BlockDisks(name string) []string {
+	classPath := fmt.Sprintf("/sys/class/block/%s", name)
+	if FileIsExist(filepath.Join(classPath, "partition")) {
+		devPath, err := filepath.EvalSymlinks(classPath)
+		if err != nil {
+			return nil
+		}
+		return BlockDisks(filepath.Base(filepath.Dir(devPath)))
+	}
+
+	slaves, err := ioutil.ReadDir(filepath.Join(classPath, "slaves"))
+	if err != nil || len(slaves) == 0 {
+		return []string{name}
+	}
+	var disks []string
+	for _, slave := range slaves {
+		disks = append(disks, BlockDisks(slave.Name())...)
+	}
+	return disks
+}
+
+// PcieAncestors 获取sysfs设备路径上的所有pcie设备bdf，包括设备本身与其上游的pcie桥。
+func PcieAncestors(sysPath string) []string {
+	devPath, err := filepath.EvalSymlinks(sysPath)
+	if err != nil {
+		return nil
+	}
+	var bdfs []string
+	for _, part := range strings.Split(devPath, "/") {
+		if IsBdf(part) {
+			bdfs = append(bdfs, part)
+		}
+	}
+	return bdfs
+}
@@ -20,10 +20,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -67,6 +70,44 @@ func ExecCommandUnblock(shellCmd string) (string, error) {
 	return strconv.Itoa(cmd.Process.Pid), nil
 }
 
+// StartSelfDetached 在新会话中后台运行当前程序，标准输出与错误输出追加到日志文件，返回进程pid。
+func StartSelfDetached(args []string, logPath string) (int, error) {
+	arsenalPath, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(arsenalPath, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// 新建会话，调用方退出后后台进程继续运行。
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// ProcessIsAlive 判断进程是否存在，并且命令行的第argIndex个参数为arg，避免pid被复用导致误判。
+func ProcessIsAlive(pid int, argIndex int, arg string) bool {
+	if pid <= 0 || syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	// cmdline中的参数以\x00分隔并以\x00结尾。
+	args := strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	return argIndex < len(args) && args[argIndex] == arg
+}
+
 // CheckEnvShellCommand shell命令依赖检查。
 func CheckEnvShellCommand(commands []string) ([]string, bool) {
 	missingCommands := make([]string, 0)
@@ -90,3 +131,12 @@ func GetArsenalLogsDir() (string, error) {
 	}
 	return fmt.Sprintf("%s/../logs/", filepath.Dir(arsenalPath)), nil
 }
+
+// GetArsenalConfDir 获取arsenal配置文件目录。
+func GetArsenalConfDir() (string, error) {
+	arsenalPath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/../conf/", filepath.Dir(arsenalPath)), nil
+}